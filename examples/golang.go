@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Go module named example.com/app with the given files
+func goProject(files map[string]string) *Directory {
+	dir := dag.Directory().WithNewFile("go.mod", "module example.com/app\n\ngo 1.22\n")
+	for name, contents := range files {
+		dir = dir.WithNewFile(name, contents)
+	}
+	return dir
+}
+
+// Check Test with race enabled catches a data race that a plain run misses
+func (m *Examples) GolangRace(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"race_test.go": `package app
+
+import "testing"
+
+func TestRace(t *testing.T) {
+	counter := 0
+	done := make(chan bool)
+	go func() {
+		counter++
+		done <- true
+	}()
+	counter++
+	<-done
+}
+`,
+	})
+
+	if _, err := dag.Golang().Test(ctx, GolangTestOpts{Source: src}); err != nil {
+		return fmt.Errorf("tests should pass without the race detector: %w", err)
+	}
+	_, err := dag.Golang().Test(ctx, GolangTestOpts{Source: src, Race: true})
+	if err == nil || !strings.Contains(err.Error(), "DATA RACE") {
+		return fmt.Errorf("race detector didn't report the data race: %v", err)
+	}
+	return nil
+}
+
+// A main package printing hello
+const helloMain = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+
+// Check stripSymbols and trimpath make the binary smaller
+func (m *Examples) GolangStripSymbols(ctx context.Context) error {
+	src := goProject(map[string]string{"main.go": helloMain})
+
+	full, err := dag.Golang().Build(nil, GolangBuildOpts{Source: src}).File("app").Size(ctx)
+	if err != nil {
+		return err
+	}
+	stripped, err := dag.Golang().Build(nil, GolangBuildOpts{Source: src, StripSymbols: true, Trimpath: true}).File("app").Size(ctx)
+	if err != nil {
+		return err
+	}
+	if stripped >= full {
+		return fmt.Errorf("stripped binary is %d bytes, not smaller than the %d byte unstripped one", stripped, full)
+	}
+	return nil
+}
+
+// Check building without a project fails clearly, and that source baked into
+// the container is built when no project is set
+func (m *Examples) GolangBakedSource(ctx context.Context) error {
+	_, err := dag.Golang().Build(nil).Sync(ctx)
+	if err == nil || !strings.Contains(err.Error(), "WithProject") {
+		return fmt.Errorf("building without a project should point at WithProject: %v", err)
+	}
+
+	src := goProject(map[string]string{"main.go": helloMain})
+	ctr := dag.Container().From("golang:1.22").WithDirectory("/src", src)
+	size, err := dag.Golang(GolangOpts{Ctr: ctr}).
+		Build(nil).
+		File("app").
+		Size(ctx)
+	if err != nil {
+		return fmt.Errorf("building the baked-in source failed: %w", err)
+	}
+	if size == 0 {
+		return fmt.Errorf("the baked-in source built an empty binary")
+	}
+	return nil
+}
+
+// Check extra go test flags reach go test, here selecting a single test
+func (m *Examples) GolangTestExtraArgs(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"app_test.go": `package app
+
+import "testing"
+
+func TestOne(t *testing.T) {}
+
+func TestTwo(t *testing.T) {}
+`,
+	})
+
+	out, err := dag.Golang().Test(ctx, GolangTestOpts{Source: src, ExtraArgs: []string{"-run", "^TestOne$"}})
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "TestOne") || strings.Contains(out, "TestTwo") {
+		return fmt.Errorf("-run ^TestOne$ should only run TestOne, got:\n%s", out)
+	}
+	return nil
+}
+
+// Check WithGoflags sets GOFLAGS for the build, both building a vendored
+// dependency and failing clearly when the vendor directory is missing
+func (m *Examples) GolangGoflagsVendor(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+		"main.go": `package main
+
+import "example.com/dep"
+
+func main() {
+	println(dep.Name)
+}
+`,
+		"vendor/modules.txt":            "# example.com/dep v1.0.0\n## explicit; go 1.22\nexample.com/dep\n",
+		"vendor/example.com/dep/dep.go": "package dep\n\nconst Name = \"dep\"\n",
+	})
+
+	vendored := dag.Golang().WithGoflags("-mod=vendor")
+	if _, err := vendored.Build(nil, GolangBuildOpts{Source: src}).File("app").Sync(ctx); err != nil {
+		return fmt.Errorf("the vendored build failed: %w", err)
+	}
+
+	_, err := vendored.Build(nil, GolangBuildOpts{Source: src.WithoutDirectory("vendor")}).Sync(ctx)
+	if err == nil || !strings.Contains(err.Error(), "go mod vendor") {
+		return fmt.Errorf("a vendored build without vendor/ should say to run go mod vendor: %v", err)
+	}
+	return nil
+}
+
+// Check WithDeadline kills a hung test long before go test's own timeout
+func (m *Examples) GolangDeadline(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"hang_test.go": `package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHang(t *testing.T) {
+	time.Sleep(5 * time.Minute)
+}
+`,
+	})
+
+	start := time.Now()
+	_, err := dag.Golang().
+		WithDeadline("20s").
+		Test(ctx, GolangTestOpts{Source: src})
+	if err == nil || !strings.Contains(err.Error(), "deadline of 20s exceeded") {
+		return fmt.Errorf("the hung test should exceed the deadline: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Minute {
+		return fmt.Errorf("the deadline took %s to abort the test", elapsed.Round(time.Second))
+	}
+	return nil
+}
+
+// Check canceling the context stops a hung test promptly
+func (m *Examples) GolangCancel(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"hang_test.go": `package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHang(t *testing.T) {
+	time.Sleep(5 * time.Minute)
+}
+`,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := dag.Golang().Test(ctx, GolangTestOpts{Source: src})
+	if err == nil {
+		return fmt.Errorf("the hung test should be canceled")
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("the test failed before the context was canceled: %w", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		return fmt.Errorf("canceling took %s to stop the test", elapsed.Round(time.Second))
+	}
+	return nil
+}
+
+// Check Build names each selected main package's binary after its directory
+func (m *Examples) GolangBuildPackages(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"cmd/a/main.go": helloMain,
+		"cmd/b/main.go": helloMain,
+	})
+
+	entries, err := dag.Golang().
+		Build(nil, GolangBuildOpts{Source: src, Packages: []string{"./cmd/a", "./cmd/b"}}).
+		Entries(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(entries)
+	if strings.Join(entries, ",") != "a,b" {
+		return fmt.Errorf("expected binaries a and b, got %v", entries)
+	}
+	return nil
+}
+
+// Check two reproducible builds of the same source are byte-identical
+func (m *Examples) GolangReproducible(ctx context.Context) error {
+	src := goProject(map[string]string{"main.go": helloMain})
+	hash := func() ([32]byte, error) {
+		// Vary an unrelated variable so the second build isn't served from cache
+		binary, err := dag.Golang().
+			WithEnv("RUN", time.Now().String()).
+			Build(nil, GolangBuildOpts{Source: src, Reproducible: true}).
+			File("app").
+			Contents(ctx)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		return sha256.Sum256([]byte(binary)), nil
+	}
+
+	first, err := hash()
+	if err != nil {
+		return err
+	}
+	second, err := hash()
+	if err != nil {
+		return err
+	}
+	if first != second {
+		return fmt.Errorf("reproducible builds differ: %x and %x", first, second)
+	}
+	return nil
+}
+
+// Check WithVersionInfo stamps the version variables of the built binary,
+// including one with a custom name
+func (m *Examples) GolangVersionInfo(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"main.go": `package main
+
+import "fmt"
+
+var version, gitCommit, date string
+
+func main() {
+	fmt.Println(version, gitCommit, date)
+}
+`,
+	})
+
+	binary := dag.Golang().
+		WithVersionInfo(GolangWithVersionInfoOpts{
+			Pkg:       "main",
+			Version:   "v1.2.3",
+			Commit:    "abc123",
+			CommitVar: "gitCommit",
+			Date:      "2024-05-01T12:00:00Z",
+		}).
+		Build(nil, GolangBuildOpts{Source: src}).
+		File("app")
+	out, err := dag.Container().From("alpine").
+		WithFile("/usr/local/bin/app", binary).
+		WithExec([]string{"app"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if want := "v1.2.3 abc123 2024-05-01T12:00:00Z"; strings.TrimSpace(out) != want {
+		return fmt.Errorf("expected the binary to print %q, got %q", want, out)
+	}
+	return nil
+}
+
+// Check failFastPackages stops at the first failing package
+func (m *Examples) GolangFailFastPackages(ctx context.Context) error {
+	failing := func(pkg, test string) string {
+		return "package " + pkg + "\n\nimport \"testing\"\n\nfunc " + test + "(t *testing.T) {\n\tt.Fatal(\"failed\")\n}\n"
+	}
+	src := goProject(map[string]string{
+		"a/a_test.go": failing("a", "TestA"),
+		"b/b_test.go": failing("b", "TestB"),
+	})
+
+	_, err := dag.Golang().Test(ctx, GolangTestOpts{Source: src, FailFastPackages: true})
+	if err == nil || !strings.Contains(err.Error(), "stopped after example.com/app/a failed, 1 packages not tested") {
+		return fmt.Errorf("expected the run to stop after package a: %v", err)
+	}
+	if strings.Contains(err.Error(), "TestB") {
+		return fmt.Errorf("package b was tested after package a failed: %v", err)
+	}
+
+	// A package that doesn't compile fails the run rather than being skipped
+	broken := goProject(map[string]string{
+		"a/a_test.go":       "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+		"b/b.go":            "package b\n\nfunc broken( {\n",
+		"c/windows_test.go": "//go:build windows\n\npackage c\n",
+	})
+	_, err = dag.Golang().Test(ctx, GolangTestOpts{Source: broken, FailFastPackages: true})
+	if err == nil || !strings.Contains(err.Error(), "stopped after example.com/app/b failed") {
+		return fmt.Errorf("expected the run to fail on the package that doesn't compile: %v", err)
+	}
+	return nil
+}
+
+// Check WithCACert adds a certificate to the trust store Go verifies against
+func (m *Examples) GolangCACert(ctx context.Context) error {
+	cert := dag.Container().From("alpine").
+		WithExec([]string{"apk", "add", "openssl"}).
+		WithExec([]string{"openssl", "req", "-x509", "-newkey", "rsa:2048", "-nodes", "-days", "1", "-subj", "/CN=example-ca", "-keyout", "/ca.key", "-out", "/ca.crt"}).
+		File("/ca.crt")
+	src := goProject(map[string]string{
+		"ca_test.go": `package app
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+)
+
+func TestTrusted(t *testing.T) {
+	data, err := os.ReadFile("testdata/ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+`,
+	}).WithFile("testdata/ca.crt", cert)
+
+	if _, err := dag.Golang().Test(ctx, GolangTestOpts{Source: src}); err == nil {
+		return fmt.Errorf("the generated certificate shouldn't be trusted without WithCACert")
+	}
+	if _, err := dag.Golang().WithCACert(cert).Test(ctx, GolangTestOpts{Source: src}); err != nil {
+		return fmt.Errorf("the certificate added with WithCACert isn't trusted: %w", err)
+	}
+	return nil
+}
+
+// Check a seeded module cache is enough to build with the network proxy off
+func (m *Examples) GolangModuleCacheDir(ctx context.Context) error {
+	tidied := dag.Container().From("golang:1.22").
+		WithEnvVariable("GOMODCACHE", "/seed").
+		WithDirectory("/src", goProject(map[string]string{
+			"main.go": `package main
+
+import (
+	"fmt"
+
+	"rsc.io/quote"
+)
+
+func main() {
+	fmt.Println(quote.Go())
+}
+`,
+		})).
+		WithWorkdir("/src").
+		WithExec([]string{"go", "mod", "tidy"})
+
+	_, err := dag.Golang().
+		WithoutCache().
+		WithModuleCacheDir(tidied.Directory("/seed")).
+		WithEnv("GOPROXY", "off").
+		Build(nil, GolangBuildOpts{Source: tidied.Directory("/src")}).
+		File("app").
+		Sync(ctx)
+	if err != nil {
+		return fmt.Errorf("building offline from the seeded module cache failed: %w", err)
+	}
+	return nil
+}
+
+// Check a module of a go.work workspace builds against its sibling module
+// without a replace directive
+func (m *Examples) GolangWorkspace(ctx context.Context) error {
+	src := dag.Directory().
+		WithNewFile("go.work", "go 1.22\n\nuse (\n\t./app\n\t./lib\n)\n").
+		WithNewFile("lib/go.mod", "module example.com/lib\n\ngo 1.22\n").
+		WithNewFile("lib/lib.go", "package lib\n\nconst Greeting = \"hello from lib\"\n").
+		WithNewFile("app/go.mod", "module example.com/app\n\ngo 1.22\n").
+		WithNewFile("app/main.go", `package main
+
+import (
+	"fmt"
+
+	"example.com/lib"
+)
+
+func main() {
+	fmt.Println(lib.Greeting)
+}
+`)
+
+	binary := dag.Golang(GolangOpts{Proj: src}).
+		WithWorkdir("app").
+		Build(nil).
+		File("app")
+	out, err := dag.Container().From("alpine").
+		WithFile("/usr/local/bin/app", binary).
+		WithExec([]string{"app"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "hello from lib" {
+		return fmt.Errorf("expected the app to print the lib greeting, got %q", out)
+	}
+	return nil
+}
+
+// Check compiler errors are part of a failed Build's error, and that
+// WithCombinedOutput keeps what a successful command wrote to stderr
+func (m *Examples) GolangCombinedOutput(ctx context.Context) error {
+	broken := goProject(map[string]string{"main.go": "package main\n\nfunc main() {\n\tmissing()\n}\n"})
+	_, err := dag.Golang().Build(nil, GolangBuildOpts{Source: broken}).Sync(ctx)
+	if err == nil || !strings.Contains(err.Error(), "undefined: missing") {
+		return fmt.Errorf("the build error should include the compiler error: %v", err)
+	}
+
+	// go test -x logs the commands it runs to stderr, starting with WORK=
+	src := goProject(map[string]string{"app_test.go": "package app\n\nimport \"testing\"\n\nfunc TestOK(t *testing.T) {}\n"})
+	opts := GolangTestOpts{Source: src, ExtraArgs: []string{"-x"}}
+	out, err := dag.Golang().Test(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(out, "WORK=") {
+		return fmt.Errorf("stderr is in the output without WithCombinedOutput")
+	}
+	out, err = dag.Golang().WithCombinedOutput().Test(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "WORK=") {
+		return fmt.Errorf("stderr is missing from the output with WithCombinedOutput:\n%s", out)
+	}
+	return nil
+}
+
+// Check CheckEmbeds reports every missing pattern, not only the first
+func (m *Examples) GolangCheckEmbeds(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"assets.go": `package app
+
+import "embed"
+
+//go:embed static
+var static embed.FS
+
+//go:embed missing.txt
+var missing string
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+`,
+		"static/index.html": "<html></html>\n",
+	})
+
+	_, err := dag.Golang().CheckEmbeds(ctx, GolangCheckEmbedsOpts{Source: src})
+	if err == nil {
+		return fmt.Errorf("the missing embeds weren't reported")
+	}
+	for _, pattern := range []string{"missing.txt", "templates/*.tmpl"} {
+		if !strings.Contains(err.Error(), "pattern "+pattern+":") {
+			return fmt.Errorf("missing pattern %s isn't reported: %v", pattern, err)
+		}
+	}
+	if strings.Contains(err.Error(), "pattern static:") {
+		return fmt.Errorf("the existing static directory is reported missing: %v", err)
+	}
+	return nil
+}
+
+// A block of a coverage profile: file:startLine.startCol,endLine.endCol statements count
+var profileBlock = regexp.MustCompile(`^(\S+\.go):(\d+)\.(\d+),(\d+)\.(\d+) (\d+) (\d+)$`)
+
+// Check Coverage returns a coverage profile go tool cover can read
+func (m *Examples) GolangCoverage(ctx context.Context) error {
+	src := goProject(map[string]string{
+		"app.go":      "package app\n\nfunc Add(a, b int) int {\n\treturn a + b\n}\n\nfunc Sub(a, b int) int {\n\treturn a - b\n}\n",
+		"app_test.go": "package app\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Fatal(\"wrong sum\")\n\t}\n}\n",
+	})
+
+	coverage := dag.Golang().Coverage(GolangCoverageOpts{Source: src})
+	profile, err := coverage.Contents(ctx)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(profile), "\n")
+	if mode := strings.TrimPrefix(lines[0], "mode: "); mode != "set" && mode != "count" && mode != "atomic" {
+		return fmt.Errorf("the profile doesn't start with a mode line: %q", lines[0])
+	}
+	covered := map[string]bool{}
+	for _, line := range lines[1:] {
+		block := profileBlock.FindStringSubmatch(line)
+		if block == nil {
+			return fmt.Errorf("malformed coverage block %q", line)
+		}
+		if block[1] != "example.com/app/app.go" {
+			return fmt.Errorf("unexpected file %s in the profile", block[1])
+		}
+		covered[block[2]] = block[7] != "0"
+	}
+	// Add's body starts on line 3 and Sub's on line 7
+	if !covered["3"] || covered["7"] {
+		return fmt.Errorf("expected only Add to be covered, got %v", covered)
+	}
+
+	// go tool cover must agree it's a valid profile for the source
+	out, err := dag.Container().From("golang:1.22").
+		WithDirectory("/src", src).
+		WithWorkdir("/src").
+		WithFile("/src/coverage.out", coverage).
+		WithExec([]string{"go", "tool", "cover", "-func", "coverage.out"}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("go tool cover can't read the profile: %w", err)
+	}
+	if !strings.Contains(out, "total:") {
+		return fmt.Errorf("go tool cover reported no total:\n%s", out)
+	}
+	return nil
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Examples struct {}
@@ -16,6 +21,202 @@ func (m *Examples) Multisync(ctx context.Context) error {
 	return err
 }
 
+// Check Multisync returns the first error and cancels the Containers still running
+func (m *Examples) MultisyncFailure(ctx context.Context) error {
+	run := time.Now().String()
+	ctrs := []*Container{
+		dag.Container().From("alpine").WithEnvVariable("RUN", run).WithExec([]string{"sh", "-c", "sleep 1 && exit 1"}),
+		dag.Container().From("alpine").WithEnvVariable("RUN", run).WithExec([]string{"sleep", "600"}),
+		dag.Container().From("alpine").WithEnvVariable("RUN", run).WithExec([]string{"true"}),
+	}
+
+	start := time.Now()
+	synced, err := dag.Utils().Multisync(ctx, ctrs)
+	if err == nil {
+		return fmt.Errorf("Multisync should fail when a container fails")
+	}
+	if synced != nil {
+		return fmt.Errorf("Multisync returned %d containers alongside its error", len(synced))
+	}
+	if !strings.Contains(err.Error(), "container 0") {
+		return fmt.Errorf("the error should come from container 0: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Minute {
+		return fmt.Errorf("the sleeping container wasn't canceled, Multisync took %s", elapsed.Round(time.Second))
+	}
+	return nil
+}
+
+// Check MultisyncResults collects every result, holds the concurrency limit
+// and doesn't cancel the other Containers when one fails
+func (m *Examples) MultisyncResults(ctx context.Context) error {
+	run := time.Now().String()
+	ctrs := append([]*Container{
+		dag.Container().From("alpine").WithEnvVariable("RUN", run).WithExec([]string{"sh", "-c", "exit 1"}),
+	}, gatedContainers(run, 4)...)
+
+	results, err := dag.Utils().MultisyncResults(ctx, ctrs, UtilsMultisyncResultsOpts{Concurrency: 2})
+	if err != nil {
+		return err
+	}
+	if len(results) != len(ctrs) {
+		return fmt.Errorf("got %d results for %d containers", len(results), len(ctrs))
+	}
+	for i, result := range results {
+		index, err := result.Index(ctx)
+		if err != nil {
+			return err
+		}
+		if index != i {
+			return fmt.Errorf("result %d has index %d", i, index)
+		}
+		failure, err := result.Error(ctx)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			if failure == "" {
+				return fmt.Errorf("container 0 should have failed")
+			}
+			continue
+		}
+		if failure != "" {
+			return fmt.Errorf("container %d was affected by the failure: %s", i, failure)
+		}
+
+		peak, err := peakRunning(ctx, result.Container())
+		if err != nil {
+			return err
+		}
+		if peak > 2 {
+			return fmt.Errorf("container %d saw %d containers running with a limit of 2", i, peak)
+		}
+	}
+	return nil
+}
+
+// Check Multisync runs no more Containers at once than the concurrency limit
+func (m *Examples) MultisyncConcurrency(ctx context.Context) error {
+	ctrs, err := dag.Utils().Multisync(ctx, gatedContainers(time.Now().String(), 6), UtilsMultisyncOpts{Concurrency: 2})
+	if err != nil {
+		return err
+	}
+	for i := range ctrs {
+		peak, err := peakRunning(ctx, &ctrs[i])
+		if err != nil {
+			return err
+		}
+		if peak > 2 {
+			return fmt.Errorf("container %d saw %d containers running with a limit of 2", i, peak)
+		}
+	}
+	return nil
+}
+
+// Containers that each mark themselves running in a shared cache volume for a
+// couple of seconds and record how many were running at once. The run ID
+// keeps the execs out of the cache
+func gatedContainers(run string, n int) []*Container {
+	gate := dag.CacheVolume("multisync-gate")
+	script := `mkdir -p /gate && touch /gate/$TASK && ls /gate | wc -l > /peak && sleep 2 && ls /gate | wc -l >> /peak && rm /gate/$TASK`
+
+	var ctrs []*Container
+	for i := 1; i <= n; i++ {
+		ctrs = append(ctrs, dag.Container().From("alpine").
+			WithEnvVariable("RUN", run).
+			WithEnvVariable("TASK", run+"-"+strconv.Itoa(i)).
+			WithMountedCache("/gate", gate, ContainerWithMountedCacheOpts{Sharing: Shared}).
+			WithExec([]string{"sh", "-c", script}))
+	}
+	return ctrs
+}
+
+// The most Containers a gated Container saw running at once
+func peakRunning(ctx context.Context, ctr *Container) (int, error) {
+	peak, err := ctr.File("/peak").Contents(ctx)
+	if err != nil {
+		return 0, err
+	}
+	most := 0
+	for _, running := range strings.Fields(peak) {
+		if n, _ := strconv.Atoi(running); n > most {
+			most = n
+		}
+	}
+	return most, nil
+}
+
+// Check reproducible tarballs of the same files are byte-identical, even when
+// the files were written at different times
+func (m *Examples) TarReproducible(ctx context.Context) error {
+	hash := func(reproducible bool) ([32]byte, error) {
+		// Sleep before writing so each run's files get a different mtime
+		dir := dag.Container().From("alpine").
+			WithEnvVariable("RUN", time.Now().String()).
+			WithExec([]string{"sh", "-c", "sleep 1 && mkdir -p /data/nested && echo hello > /data/hello.txt && echo world > /data/nested/world.txt"}).
+			Directory("/data")
+		tarball, err := dag.Utils().Tar(dir, UtilsTarOpts{Level: 9, Reproducible: reproducible, Root: "/release"}).Contents(ctx)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		return sha256.Sum256([]byte(tarball)), nil
+	}
+
+	var hashes [4][32]byte
+	for i := range hashes {
+		var err error
+		if hashes[i], err = hash(i < 2); err != nil {
+			return err
+		}
+	}
+	if hashes[0] != hashes[1] {
+		return fmt.Errorf("reproducible tarballs differ: %x and %x", hashes[0], hashes[1])
+	}
+	if hashes[2] == hashes[3] {
+		return fmt.Errorf("tarballs match without reproducible mode, the runs didn't change the mtimes")
+	}
+	return nil
+}
+
+// Check archives made with Archive extract back to the same files, and that
+// Extract rejects archives escaping their root
+func (m *Examples) Extract(ctx context.Context) error {
+	dir := dag.Directory().
+		WithNewFile("hello.txt", "hello").
+		WithNewFile("nested/world.txt", "world")
+
+	for _, format := range []string{"tar.gz", "tar", "zip"} {
+		archive := dag.Utils().Archive(dir, UtilsArchiveOpts{Format: format, Root: "/assets"})
+		extracted := dag.Utils().Extract(archive, UtilsExtractOpts{Format: format}).Directory("assets")
+		for name, want := range map[string]string{"hello.txt": "hello", "nested/world.txt": "world"} {
+			got, err := extracted.File(name).Contents(ctx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", format, err)
+			}
+			if got != want {
+				return fmt.Errorf("%s: %s contains %q, want %q", format, name, got, want)
+			}
+		}
+	}
+
+	malicious := map[string]string{
+		"absolute path": "tar -cPf /evil.tar /etc/hostname",
+		"parent path":   "mkdir -p /a/b && cd /a/b && echo x > ../x && tar -cPf /evil.tar ../x",
+		"symlink":       "mkdir /a && ln -s /etc /a/etc && tar -cf /evil.tar -C /a etc",
+		"through link":  "mkdir /a && ln -s ../.. /a/up && echo x > /tmp/x && tar -cf /evil.tar -C /a up up/tmp/x",
+	}
+	for name, script := range malicious {
+		archive := dag.Container().From("alpine").
+			WithExec([]string{"apk", "add", "--no-cache", "tar"}).
+			WithExec([]string{"sh", "-c", script}).
+			File("/evil.tar")
+		if _, err := dag.Utils().Extract(archive, UtilsExtractOpts{Format: "tar"}).Sync(ctx); err == nil {
+			return fmt.Errorf("archive with %s was extracted", name)
+		}
+	}
+	return nil
+}
+
 func (m *Examples) Codecov(ctx context.Context, token *Secret) (string, error) {
 	coverage := `
 mode: atomic
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Run go vet, golangci-lint and govulncheck, returning a result for each
+//
+// The checks run concurrently. A failure in any enabled check fails the call,
+// with the output of every check included in the error
+func (g *Golang) Analyze(
+	ctx context.Context,
+	// The Go source code to analyze
+	// +optional
+	source *Directory,
+	// Packages to analyze
+	// +optional
+	// +default="./..."
+	component string,
+	// Run go vet
+	// +optional
+	// +default=true
+	vet bool,
+	// Run golangci-lint
+	// +optional
+	// +default=true
+	lint bool,
+	// Run govulncheck
+	// +optional
+	// +default=true
+	vulncheck bool,
+) ([]*StageResult, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	checks := []struct {
+		name    string
+		enabled bool
+		run     func(ctx context.Context) (string, error)
+	}{
+		{"vet", vet, func(ctx context.Context) (string, error) {
+			return g.vet(ctx, component)
+		}},
+		{"lint", lint, func(ctx context.Context) (string, error) {
+			return g.GolangciLint(ctx, nil, component, false, 0, "", false, "")
+		}},
+		{"vulncheck", vulncheck, func(ctx context.Context) (string, error) {
+			return g.Vulncheck(ctx, nil, component, nil)
+		}},
+	}
+
+	results := make([]*StageResult, len(checks))
+	// Findings are reported in the results, only an error running a check,
+	// e.g. a failed tool install, cancels the others
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i, check := range checks {
+		i, check := i, check
+		if !check.enabled {
+			results[i] = &StageResult{Name: check.name, Passed: true, Output: "skipped: disabled"}
+			continue
+		}
+		eg.Go(func() error {
+			out, err := check.run(egCtx)
+			result, err := checkResult(check.name, out, err)
+			results[i] = result
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err := stagesFailed(results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Run golangci-lint in every module of a monorepo, concurrently
+//
+// Modules are found by their go.mod files, skipping vendor and testdata. Each is
+// linted from its own directory, so a .golangci.yml in the module is used
+func (g *Golang) LintModules(
+	ctx context.Context,
+	// The Go source code to lint
+	// +optional
+	source *Directory,
+	// The most modules to lint at once
+	// +optional
+	// +default=4
+	concurrency int,
+) ([]*StageResult, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", concurrency)
+	}
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	src, err := g.projectSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gomods, err := src.Glob(ctx, "**/go.mod")
+	if err != nil {
+		return nil, err
+	}
+	var modules []string
+	for _, gomod := range gomods {
+		dir := path.Dir(gomod)
+		if !strings.Contains("/"+dir+"/", "/vendor/") && !strings.Contains("/"+dir+"/", "/testdata/") {
+			modules = append(modules, dir)
+		}
+	}
+	sort.Strings(modules)
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no go.mod found in the project")
+	}
+
+	lint, err := g.lintContainer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*StageResult, len(modules))
+	// Only an error running golangci-lint cancels the other modules
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	for i, module := range modules {
+		i, module := i, module
+		eg.Go(func() error {
+			out, err := g.span(egCtx, "lint", func(ctx context.Context) (string, error) {
+				command := []string{"golangci-lint", "run", "-v", "--allow-parallel-runners", "--timeout", "5m", "./..."}
+				return lint.
+					WithWorkdir(path.Join(PROJ_MOUNT, module)).
+					WithExec(g.deadlineCommand(command)).
+					Stdout(ctx)
+			})
+			result, err := checkResult(module, out, err)
+			results[i] = result
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err := stagesFailed(results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Turn the outcome of a check into a result
+//
+// A non-zero exit of the tool is a finding and fails the check, with the tool's
+// output kept whichever stream it wrote to. Any other error, including failing
+// to install the tool or fetch what it needs, means the check couldn't run
+func checkResult(name, out string, err error) (*StageResult, error) {
+	result := &StageResult{Name: name, Ran: true, Passed: err == nil, Output: out}
+	if err == nil {
+		return result, nil
+	}
+	var execErr *ExecError
+	if !errors.As(err, &execErr) || errors.Is(err, ErrToolInstall) || errors.Is(err, ErrVulnDB) {
+		return nil, err
+	}
+	result.Output = strings.TrimSpace(execErr.Stdout + "\n" + execErr.Stderr)
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The build information embedded in a Go binary
+type BinaryInfo struct {
+	// The Go version the binary was built with, e.g. go1.22.5
+	GoVersion string
+	// The main package path
+	Path string
+	// The main module
+	Main *ModuleInfo
+	// The modules the binary depends on
+	Deps []*ModuleInfo
+	// Build settings like GOOS, -ldflags and vcs.revision
+	Settings []*BuildSetting
+}
+
+// A module recorded in a binary's build information
+type ModuleInfo struct {
+	Path    string
+	Version string
+	Sum     string
+	// The module replacing this one, if any
+	Replace *ModuleInfo
+}
+
+// A build setting recorded in a binary's build information
+type BuildSetting struct {
+	Key   string
+	Value string
+}
+
+// Read the modules and build settings embedded in a Go binary with `go version -m`
+func (g *Golang) BuildInfo(
+	ctx context.Context,
+	// The binary to inspect
+	binary *File,
+) (*BinaryInfo, error) {
+	out, err := g.Ctr.
+		WithMountedFile("/tmp/binary", binary).
+		WithExec([]string{"go", "version", "-m", "/tmp/binary"}).
+		Stdout(ctx)
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		return nil, fmt.Errorf("unable to read build info, is it a Go binary? %s", strings.TrimSpace(execErr.Stderr))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseBuildInfo(out)
+}
+
+// Parse `go version -m` output
+func parseBuildInfo(out string) (*BinaryInfo, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	_, goVersion, found := strings.Cut(lines[0], ": ")
+	if !found || !strings.HasPrefix(goVersion, "go") {
+		return nil, fmt.Errorf("no Go build info found in the binary")
+	}
+
+	info := &BinaryInfo{GoVersion: goVersion}
+	var last *ModuleInfo
+	for _, line := range lines[1:] {
+		fields := strings.Split(strings.TrimPrefix(line, "\t"), "\t")
+		switch fields[0] {
+		case "path":
+			if len(fields) > 1 {
+				info.Path = fields[1]
+			}
+		case "mod":
+			info.Main = moduleInfo(fields[1:])
+			last = info.Main
+		case "dep":
+			last = moduleInfo(fields[1:])
+			info.Deps = append(info.Deps, last)
+		case "=>":
+			if last != nil {
+				last.Replace = moduleInfo(fields[1:])
+			}
+		case "build":
+			if len(fields) > 1 {
+				key, value, _ := strings.Cut(fields[1], "=")
+				info.Settings = append(info.Settings, &BuildSetting{Key: key, Value: value})
+			}
+		}
+	}
+	return info, nil
+}
+
+// A module from the path, version and sum fields of a mod, dep or => line
+func moduleInfo(fields []string) *ModuleInfo {
+	m := &ModuleInfo{}
+	for i, f := range fields {
+		switch i {
+		case 0:
+			m.Path = f
+		case 1:
+			m.Version = f
+		case 2:
+			m.Sum = f
+		}
+	}
+	return m
+}
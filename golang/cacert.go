@@ -0,0 +1,32 @@
+package main
+
+import "fmt"
+
+// Installs the mounted CA certificates into the system trust store, which Go
+// reads from /etc/ssl/certs/ca-certificates.crt on both Debian and Alpine
+const INSTALL_CA_CERTS = `mkdir -p /usr/local/share/ca-certificates
+cp /tmp/ca-certs/* /usr/local/share/ca-certificates/
+if command -v update-ca-certificates >/dev/null 2>&1; then update-ca-certificates && exit 0; fi
+# Without the tooling append to the bundle directly
+cat /tmp/ca-certs/* >> /etc/ssl/certs/ca-certificates.crt`
+
+// Trust a CA certificate, e.g. of a TLS-intercepting corporate proxy
+//
+// The PEM certificate is added to the system trust store before any network
+// access, so go commands, git and the tools installed with WithGoTool trust it
+func (g *Golang) WithCACert(cert *File) *Golang {
+	g.CACerts = append(g.CACerts, cert)
+	return g
+}
+
+// Private func installing the certificates added with WithCACert
+func (g *Golang) withCACerts(c *Container) *Container {
+	if len(g.CACerts) == 0 {
+		return c
+	}
+	for i, cert := range g.CACerts {
+		// update-ca-certificates only picks up files ending in .crt
+		c = c.WithMountedFile(fmt.Sprintf("/tmp/ca-certs/dagger-%d.crt", i), cert)
+	}
+	return c.WithExec([]string{"sh", "-c", INSTALL_CA_CERTS})
+}
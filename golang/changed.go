@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+// List the packages in head affected by the changes from base
+//
+// A package is affected when a file in its directory changed, including its
+// testdata, or when any package it or its tests transitively import is
+// affected. Changes to go.mod, go.sum or go.work affect every package
+func (g *Golang) ChangedPackages(
+	ctx context.Context,
+	// The source tree before the change
+	base *Directory,
+	// The source tree after the change
+	head *Directory,
+) ([]string, error) {
+	// git diff exits 1 when there are differences, only fail on other errors
+	script := `git diff --no-index --name-only a b; code=$?; [ $code -le 1 ] || exit $code`
+	out, err := g.Ctr.
+		WithMountedDirectory("/diff/a", base).
+		WithMountedDirectory("/diff/b", head).
+		WithWorkdir("/diff").
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs, err := g.List(ctx, head, "./...")
+	if err != nil {
+		return nil, err
+	}
+
+	all := false
+	dirs := map[string]bool{}
+	for _, file := range strings.Fields(out) {
+		// Paths are prefixed with the side of the diff they're from, a/ or b/
+		_, file, _ = strings.Cut(file, "/")
+		switch path.Base(file) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			all = true
+		}
+		dirs[packageDirOf(file)] = true
+	}
+
+	changed := map[string]bool{}
+	for _, pkg := range pkgs {
+		// Package directories are relative to the workdir, the diff to the project root
+		if all || dirs[path.Join(g.Workdir, pkg.Dir)] {
+			changed[pkg.ImportPath] = true
+		}
+	}
+
+	return affectedPackages(pkgs, changed), nil
+}
+
+// Private func listing the packages affected by the changed ones, sorted
+//
+// Deps only covers the package itself, so test imports are followed too: a
+// package whose tests use a changed helper has to be tested again
+func affectedPackages(pkgs []*Package, changed map[string]bool) []string {
+	// Packages whose non-test code is affected
+	built := map[string]bool{}
+	for _, pkg := range pkgs {
+		hit := changed[pkg.ImportPath]
+		for _, dep := range pkg.Deps {
+			if hit {
+				break
+			}
+			hit = changed[dep]
+		}
+		built[pkg.ImportPath] = hit
+	}
+
+	var affected []string
+	for _, pkg := range pkgs {
+		hit := built[pkg.ImportPath]
+		for _, imp := range append(append([]string{}, pkg.TestImports...), pkg.XTestImports...) {
+			if hit {
+				break
+			}
+			hit = built[imp]
+		}
+		if hit {
+			affected = append(affected, pkg.ImportPath)
+		}
+	}
+	sort.Strings(affected)
+	return affected
+}
+
+// The directory of the package a file belongs to, relative to the project root
+//
+// Files under testdata belong to the package containing the testdata directory
+func packageDirOf(file string) string {
+	dir := path.Dir(file)
+	if i := strings.Index("/"+dir+"/", "/testdata/"); i >= 0 {
+		dir = path.Clean("/" + dir)[:i]
+		dir = strings.TrimPrefix(dir, "/")
+		if dir == "" {
+			dir = "."
+		}
+	}
+	return dir
+}
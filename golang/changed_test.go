@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAffectedPackages(t *testing.T) {
+	pkgs := []*Package{
+		{ImportPath: "example.com/app/helper"},
+		{ImportPath: "example.com/app/lib", Deps: []string{"fmt"}},
+		{ImportPath: "example.com/app/cmd", Deps: []string{"example.com/app/lib", "fmt"}},
+		// Only the tests import the helper
+		{ImportPath: "example.com/app/api", Deps: []string{"fmt"}, TestImports: []string{"example.com/app/helper", "testing"}},
+		{ImportPath: "example.com/app/db", XTestImports: []string{"example.com/app/db", "example.com/app/helper"}},
+		{ImportPath: "example.com/app/other", TestImports: []string{"testing"}},
+	}
+
+	got := affectedPackages(pkgs, map[string]bool{"example.com/app/lib": true})
+	if want := []string{"example.com/app/cmd", "example.com/app/lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lib changed: got %v, want %v", got, want)
+	}
+
+	got = affectedPackages(pkgs, map[string]bool{"example.com/app/helper": true})
+	if want := []string{"example.com/app/api", "example.com/app/db", "example.com/app/helper"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("helper changed: got %v, want %v", got, want)
+	}
+}
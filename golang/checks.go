@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Number of modules to report as the largest contributors to the dependency graph
+	TOP_CONTRIBUTORS = 5
+	// Number of matching symbols to report for each forbidden entry
+	MAX_SYMBOL_MATCHES = 10
+)
+
+// Fail if the dependency graph contains more modules than the budget allows
+func (g *Golang) DepCountGate(
+	ctx context.Context,
+	// The Go source code to check
+	// +optional
+	source *Directory,
+	// The maximum number of modules allowed in the dependency graph
+	max int,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	all, err := ctr.WithExec([]string{"go", "list", "-m", "all"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	graph, err := ctr.WithExec([]string{"go", "mod", "graph"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// The first line of `go list -m all` is the main module itself
+	count := len(strings.Fields(all)) - 1
+	if count < 0 {
+		count = 0
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "%d modules in the dependency graph (budget %d)\n", count, max)
+	contributors := topContributors(graph, TOP_CONTRIBUTORS)
+	if len(contributors) > 0 {
+		report.WriteString("Top contributors (transitive modules pulled in):\n")
+		for _, c := range contributors {
+			fmt.Fprintf(&report, "  %s: %d\n", c.module, c.count)
+		}
+	}
+
+	if count > max {
+		return "", fmt.Errorf("dependency budget exceeded by %d modules\n%s", count-max, report.String())
+	}
+	return report.String(), nil
+}
+
+type contributor struct {
+	module string
+	count  int
+}
+
+// Rank the direct dependencies of the main module by the number of
+// distinct modules reachable through them in `go mod graph` output
+func topContributors(graph string, n int) []contributor {
+	edges := map[string][]string{}
+	root := ""
+	for _, line := range strings.Split(graph, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		from, to := fields[0], fields[1]
+		if root == "" && !strings.Contains(from, "@") {
+			root = from
+		}
+		edges[from] = append(edges[from], to)
+	}
+
+	var result []contributor
+	for _, direct := range edges[root] {
+		seen := map[string]bool{}
+		stack := append([]string{}, edges[direct]...)
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[node] {
+				continue
+			}
+			seen[node] = true
+			stack = append(stack, edges[node]...)
+		}
+
+		// Versions are resolved by MVS, so only count distinct module paths
+		paths := map[string]bool{}
+		for node := range seen {
+			paths[modulePathOf(node)] = true
+		}
+		delete(paths, modulePathOf(direct))
+		delete(paths, root)
+		result = append(result, contributor{module: direct, count: len(paths)})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].count != result[j].count {
+			return result[i].count > result[j].count
+		}
+		return result[i].module < result[j].module
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// Strip the @version suffix from a `go mod graph` node
+func modulePathOf(node string) string {
+	path, _, _ := strings.Cut(node, "@")
+	return path
+}
+
+// Fail if the binary links any forbidden symbol or package
+//
+// Entries can be a package path such as net/http/pprof, matching every symbol
+// in that package, or a fully qualified symbol such as net/http/pprof.Index
+func (g *Golang) SymbolCheck(
+	ctx context.Context,
+	// The Go binary to inspect
+	binary *File,
+	// Symbols or package paths that must not be linked
+	forbidden []string,
+) (string, error) {
+	out, err := g.Ctr.
+		WithMountedFile("/tmp/binary", binary).
+		WithExec([]string{"go", "tool", "nm", "/tmp/binary"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the symbol table: %w", err)
+	}
+
+	var symbols []string
+	for _, line := range strings.Split(out, "\n") {
+		// Lines are `address type name`, undefined symbols have no address
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		symbols = append(symbols, fields[len(fields)-1])
+	}
+
+	var report strings.Builder
+	for _, f := range forbidden {
+		var matches []string
+		for _, sym := range symbols {
+			if symbolMatches(sym, f) {
+				matches = append(matches, sym)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		fmt.Fprintf(&report, "%s is linked (%d symbols):\n", f, len(matches))
+		if len(matches) > MAX_SYMBOL_MATCHES {
+			matches = matches[:MAX_SYMBOL_MATCHES]
+		}
+		for _, m := range matches {
+			fmt.Fprintf(&report, "  %s\n", m)
+		}
+	}
+
+	if report.Len() > 0 {
+		return "", fmt.Errorf("forbidden symbols found in binary\n%s", report.String())
+	}
+	return fmt.Sprintf("none of %d forbidden entries are linked", len(forbidden)), nil
+}
+
+// Whether a symbol is, or belongs to the package, named by a forbidden entry
+//
+// Package paths can also appear inside type descriptors and method
+// expressions, e.g. type:*net/http/pprof.handler or net/http/pprof.(*handler).ServeHTTP
+func symbolMatches(symbol, forbidden string) bool {
+	if symbol == forbidden {
+		return true
+	}
+	prefix := forbidden + "."
+	for i := strings.Index(symbol, prefix); i >= 0; {
+		if i == 0 || strings.ContainsRune(" *:([,", rune(symbol[i-1])) {
+			return true
+		}
+		next := strings.Index(symbol[i+1:], prefix)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+// Report Go files without a matching _test.go file and test files without a source file
+//
+// Files are paired by name within the same directory, so foo.go pairs with
+// foo_test.go. vendor and testdata directories and doc.go files are ignored
+func (g *Golang) TestPairing(
+	ctx context.Context,
+	// The Go source code to check
+	// +optional
+	source *Directory,
+	// Glob patterns for files exempt from pairing, matched against the path and the file name
+	// +optional
+	exempt []string,
+) ([]string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	src, err := g.projectSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := src.Glob(ctx, "**/*.go")
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	var candidates []string
+	for _, file := range files {
+		if skipPairing(file, exempt) {
+			continue
+		}
+		present[file] = true
+		candidates = append(candidates, file)
+	}
+	sort.Strings(candidates)
+
+	var unpaired []string
+	for _, file := range candidates {
+		if test, ok := strings.CutSuffix(file, "_test.go"); ok {
+			if !present[test+".go"] {
+				unpaired = append(unpaired, "orphan test: "+file)
+			}
+			continue
+		}
+		if !present[strings.TrimSuffix(file, ".go")+"_test.go"] {
+			unpaired = append(unpaired, "missing test: "+file)
+		}
+	}
+	return unpaired, nil
+}
+
+// Whether a file is excluded from test pairing
+func skipPairing(file string, exempt []string) bool {
+	for _, dir := range strings.Split(path.Dir(file), "/") {
+		if dir == "vendor" || dir == "testdata" {
+			return true
+		}
+	}
+	if path.Base(file) == "doc.go" {
+		return true
+	}
+	for _, pattern := range exempt {
+		if ok, _ := path.Match(pattern, file); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// The differences between two directories
+type DiffResult struct {
+	// Unified diff of the changes, covering added, deleted and modified files
+	Diff string
+	// Whether the directories differ
+	Changed bool
+}
+
+// Compare two directories, for example the project before and after a
+// generate, fmt or mod tidy step
+func (g *Golang) Diff(
+	ctx context.Context,
+	// The directory before the change
+	before *Directory,
+	// The directory after the change
+	after *Directory,
+) (*DiffResult, error) {
+	// git diff exits 1 when there are differences, record it rather than failing
+	script := `git diff --no-index --no-color --no-prefix a b > /tmp/diff; code=$?; [ $code -le 1 ] || exit $code; echo $code > /tmp/diff-code`
+	ctr := g.Ctr.
+		WithMountedDirectory("/diff/a", before).
+		WithMountedDirectory("/diff/b", after).
+		WithWorkdir("/diff").
+		WithExec([]string{"sh", "-c", script})
+
+	code, err := ctr.File("/tmp/diff-code").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := ctr.File("/tmp/diff").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffResult{
+		Diff:    diff,
+		Changed: strings.TrimSpace(code) == "1",
+	}, nil
+}
+
+// Return the size of a binary in bytes
+func (g *Golang) Size(
+	ctx context.Context,
+	// The binary to measure
+	binary *File,
+) (int, error) {
+	return binary.Size(ctx)
+}
+
+// Fail when a binary is over its size budget
+//
+// The error includes the binary's size by section to help find the growth
+func (g *Golang) CheckSize(
+	ctx context.Context,
+	// The binary to measure
+	binary *File,
+	// The largest allowed size in bytes
+	maxBytes int,
+) (string, error) {
+	size, err := binary.Size(ctx)
+	if err != nil {
+		return "", err
+	}
+	if size <= maxBytes {
+		return fmt.Sprintf("binary is %d bytes, %d under the budget of %d", size, maxBytes-size, maxBytes), nil
+	}
+
+	msg := fmt.Sprintf("binary is %d bytes, %d over the budget of %d", size, size-maxBytes, maxBytes)
+	if sections, err := g.SizeSections(ctx, binary); err == nil {
+		msg += "\n" + sections
+	}
+	return "", fmt.Errorf("%s", msg)
+}
+
+// Break down the size of a binary by section, e.g. .text and .rodata, with `size -A`
+//
+// Uses the container's binutils, which must understand the binary's format
+func (g *Golang) SizeSections(
+	ctx context.Context,
+	// The binary to inspect
+	binary *File,
+) (string, error) {
+	out, err := g.Ctr.
+		WithMountedFile("/tmp/binary", binary).
+		WithExec([]string{"size", "-A", "-d", "/tmp/binary"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the binary's sections: %w", err)
+	}
+	return out, nil
+}
+
+// Check the downloaded modules match the checksums in go.sum with `go mod verify`
+//
+// The error lists each module whose files were modified after download
+func (g *Golang) VerifyModules(
+	ctx context.Context,
+	// The Go source code to verify
+	// +optional
+	source *Directory,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ctr.
+		WithExec([]string{"go", "mod", "download"}).
+		WithExec([]string{"sh", "-c", "go mod verify 2>&1"}).
+		Stdout(ctx)
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		var failed []string
+		for _, line := range strings.Split(execErr.Stdout, "\n") {
+			// Failures are reported as `module version: reason`
+			if strings.Contains(line, " has been modified") || strings.Contains(line, "missing ziphash") {
+				failed = append(failed, line)
+			}
+		}
+		if len(failed) == 0 {
+			return "", fmt.Errorf("go mod verify failed\n%s", execErr.Stdout)
+		}
+		return "", fmt.Errorf("%d modules failed verification\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// Checks each pair of package directory and //go:embed pattern arguments,
+// printing the index of the pairs whose pattern matches no files
+const CHECK_EMBEDS = `IFS=
+i=0
+while [ $# -gt 0 ]; do
+  found=
+  for f in "$1"/$2; do [ -e "$f" ] && found=1 && break; done
+  [ -n "$found" ] || echo "$i"
+  i=$((i+1))
+  shift 2
+done`
+
+// Check the files referenced by //go:embed exist, before building
+//
+// The compiler only reports the first missing pattern of the first package it
+// reaches, and go list only the first of each package. This matches every
+// pattern in the container and lists each one that matches no files
+func (g *Golang) CheckEmbeds(
+	ctx context.Context,
+	// The Go source code to check
+	// +optional
+	source *Directory,
+	// The packages to check
+	// +optional
+	// +default="./..."
+	component string,
+) ([]*Package, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	pkgs, err := g.List(ctx, nil, component)
+	if err != nil {
+		return nil, err
+	}
+
+	type embed struct {
+		pkg     *Package
+		pattern string
+	}
+	var embedding []*Package
+	var embeds []embed
+	var args []string
+	for _, pkg := range pkgs {
+		if len(pkg.EmbedPatterns) == 0 {
+			continue
+		}
+		embedding = append(embedding, pkg)
+		for _, pattern := range pkg.EmbedPatterns {
+			embeds = append(embeds, embed{pkg, pattern})
+			// all: only changes whether hidden files are embedded
+			args = append(args, pkg.Dir, strings.TrimPrefix(pattern, "all:"))
+		}
+	}
+	if len(embeds) == 0 {
+		return embedding, nil
+	}
+
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ctr.
+		WithExec(append([]string{"sh", "-c", CHECK_EMBEDS, "sh"}, args...)).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	reported := map[*Package]bool{}
+	for _, line := range strings.Fields(out) {
+		i, err := strconv.Atoi(line)
+		if err != nil || i >= len(embeds) {
+			return nil, fmt.Errorf("unexpected embed check output %q", line)
+		}
+		e := embeds[i]
+		missing = append(missing, fmt.Sprintf("%s (%s): pattern %s: no matching files found", e.pkg.ImportPath, e.pkg.Dir, e.pattern))
+		reported[e.pkg] = true
+	}
+	// Patterns go list rejects for other reasons match files on disk, e.g. a
+	// directory with no embeddable files or an invalid pattern
+	for _, e := range embeds {
+		if !reported[e.pkg] && strings.Contains(e.pkg.Error, "pattern "+e.pattern+":") {
+			missing = append(missing, fmt.Sprintf("%s (%s): %s", e.pkg.ImportPath, e.pkg.Dir, e.pkg.Error))
+			reported[e.pkg] = true
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%d embed patterns failed\n%s", len(missing), strings.Join(missing, "\n"))
+	}
+	return embedding, nil
+}
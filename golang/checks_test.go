@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSymbolMatches(t *testing.T) {
+	tests := []struct {
+		symbol    string
+		forbidden string
+		want      bool
+	}{
+		{"net/http/pprof.Index", "net/http/pprof", true},
+		{"net/http/pprof.Index", "net/http/pprof.Index", true},
+		{"net/http/pprof.(*handler).ServeHTTP", "net/http/pprof", true},
+		{"type:*net/http/pprof.handler", "net/http/pprof", true},
+		{"main.wrap[net/http/pprof.handler]", "net/http/pprof", true},
+		{"main.wrap[int,net/http/pprof.handler]", "net/http/pprof", true},
+		{"net/http.Serve", "net/http/pprof", false},
+		{"example.com/net/http/pprof.Index", "net/http/pprof", false},
+		{"net/http/pprofx.Index", "net/http/pprof", false},
+	}
+	for _, tt := range tests {
+		if got := symbolMatches(tt.symbol, tt.forbidden); got != tt.want {
+			t.Errorf("symbolMatches(%q, %q) = %v, want %v", tt.symbol, tt.forbidden, got, tt.want)
+		}
+	}
+}
+
+func TestTopContributors(t *testing.T) {
+	graph := `example.com/app example.com/big@v1.0.0
+example.com/app example.com/small@v1.0.0
+example.com/app example.com/leaf@v1.0.0
+example.com/big@v1.0.0 example.com/x@v1.0.0
+example.com/big@v1.0.0 example.com/y@v1.0.0
+example.com/x@v1.0.0 example.com/z@v1.0.0
+example.com/x@v1.0.0 example.com/y@v1.1.0
+example.com/small@v1.0.0 example.com/x@v1.0.0
+`
+	got := topContributors(graph, 2)
+	want := []contributor{
+		// x, y and z, with y counted once across versions
+		{module: "example.com/big@v1.0.0", count: 3},
+		{module: "example.com/small@v1.0.0", count: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("topContributors() = %+v, want %+v", got, want)
+	}
+}
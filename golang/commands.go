@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Show the environment and commands Build or Test would run, without running them
+//
+// The setup prepare runs first is listed too: the C compiler for race, CA
+// certificates, SSH, WithGoTool installs and the module download. Env vars set
+// with WithEnvFromSecret are listed with their values masked. Nothing is
+// executed, only the project is read to detect a go.work and a go.mod. Source
+// baked into the container isn't read, so go.work isn't detected for it
+func (g *Golang) Commands(
+	ctx context.Context,
+	// The Go source code the operation would run on
+	// +optional
+	source *Directory,
+	// The operation, build or test
+	// +optional
+	// +default="build"
+	operation string,
+	// Arguments to `go build`, or extra flags for `go test`
+	// +optional
+	args []string,
+	// The packages to test
+	// +optional
+	// +default="./..."
+	component string,
+	// The architecture for GOARCH
+	// +optional
+	arch string,
+	// The operating system for GOOS
+	// +optional
+	os string,
+	// Remove file system paths from the binary with -trimpath
+	// +optional
+	trimpath bool,
+	// Strip the symbol table and DWARF debug info with -ldflags "-s -w"
+	// +optional
+	stripSymbols bool,
+	// Main packages to build, each into a binary named after its directory
+	// +optional
+	packages []string,
+	// Build byte-for-byte reproducible binaries
+	// +optional
+	reproducible bool,
+	// Cross-compile cgo code using zig as the C toolchain
+	// +optional
+	useZig bool,
+	// Run the tests with the race detector
+	// +optional
+	race bool,
+	// Location of the coverprofile
+	// +optional
+	// +default="coverage.out"
+	coverageLocation string,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	// Reading source baked into the container would mean running it
+	var work string
+	if g.Proj != nil {
+		var err error
+		work, err = g.workspaceFile(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	env := g.envVars(work)
+
+	var setup []string
+	if race && operation == "test" {
+		setup = append(setup, "# install a C compiler for cgo unless the image has one")
+	}
+	if len(g.CACerts) > 0 {
+		setup = append(setup, fmt.Sprintf("# install %d CA certificates into the system trust store", len(g.CACerts)))
+	}
+	if g.SSHKey != nil {
+		env = append(env, &EnvVar{Name: "GIT_SSH_COMMAND", Value: GIT_SSH_COMMAND})
+		setup = append(setup, fmt.Sprintf("# mount the SSH key at %s", SSH_KEY_PATH), shellJoin(g.sshConfigCommand()))
+	}
+	if len(g.Tools) > 0 {
+		env = append(env, &EnvVar{Name: "GOBIN", Value: TOOLS_BIN})
+		for _, tool := range g.Tools {
+			setup = append(setup, shellJoin(installCommand(tool)))
+		}
+	}
+	if g.Proj != nil && work == "" && !hasFlagValue(strings.Fields(g.goflags(work)), "mod", "vendor") {
+		dir := g.Proj
+		if g.Workdir != "" {
+			dir = dir.Directory(g.Workdir)
+		}
+		entries, err := dir.Entries(ctx)
+		if err != nil {
+			return "", err
+		}
+		if contains(entries, "go.mod") {
+			setup = append(setup, shellJoin([]string{"sh", "-c", DOWNLOAD_MODULES}))
+		}
+	}
+
+	var commands [][]string
+	var err error
+	switch operation {
+	case "", "build":
+		if arch == "" {
+			arch = runtime.GOARCH
+		}
+		if os == "" {
+			os = runtime.GOOS
+		}
+		env = append(env, &EnvVar{Name: "GOARCH", Value: arch}, &EnvVar{Name: "GOOS", Value: os})
+		if useZig {
+			zig, err := zigEnv(os, arch)
+			if err != nil {
+				return "", err
+			}
+			env = append(env, zig...)
+		}
+		if reproducible {
+			env = append(env, &EnvVar{Name: "SOURCE_DATE_EPOCH", Value: "0"})
+		}
+		commands, err = g.buildCommands(args, os, trimpath, stripSymbols, packages, reproducible)
+		if err != nil {
+			return "", err
+		}
+	case "test":
+		if component == "" {
+			component = "./..."
+		}
+		if race {
+			env = append(env, &EnvVar{Name: "CGO_ENABLED", Value: "1"})
+		}
+		commands = [][]string{testCommand([]string{component}, coverageLocation, race, args)}
+	default:
+		return "", fmt.Errorf("unsupported operation %q, valid values: build, test", operation)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# workdir %s\n", g.workdir())
+	for _, e := range env {
+		fmt.Fprintf(&out, "%s=%s\n", e.Name, shellQuote(e.Value))
+	}
+	for _, e := range g.SecretEnv {
+		fmt.Fprintf(&out, "%s=***\n", e.Name)
+	}
+	for _, line := range setup {
+		fmt.Fprintln(&out, line)
+	}
+	for _, command := range commands {
+		fmt.Fprintln(&out, shellJoin(g.deadlineCommand(command)))
+	}
+	return out.String(), nil
+}
+
+// Private func returning the GOFLAGS for the commands
+func (g *Golang) goflags(work string) string {
+	goflags := g.Goflags
+	if work != "" {
+		// The workspace resolves the modules, -mod isn't allowed in workspace mode
+		goflags = strings.Join(withoutFlag(strings.Fields(goflags), "mod"), " ")
+	}
+	if g.NoCache {
+		goflags = strings.TrimSpace(goflags + " -count=1")
+	}
+	return goflags
+}
+
+// Private func returning the env vars set for the commands, in the order
+// they're applied so later ones win
+func (g *Golang) envVars(work string) []*EnvVar {
+	var env []*EnvVar
+	set := func(name, value string) {
+		env = append(env, &EnvVar{Name: name, Value: value})
+	}
+	if g.Tmpfs {
+		set("TMPDIR", TMPFS_DIR)
+	}
+	if g.GoEnv != nil {
+		set("GOENV", GOENV_PATH)
+	}
+	if work != "" {
+		set("GOWORK", path.Join(PROJ_MOUNT, work))
+	}
+	if g.NoCache {
+		set("GOCACHE", "/tmp/go-build")
+		// Nothing should come from Dagger's cache either, always re-run
+		set("NO_CACHE", time.Now().String())
+	}
+	if goflags := g.goflags(work); goflags != "" {
+		set("GOFLAGS", goflags)
+	}
+	if g.Goexperiment != "" {
+		set("GOEXPERIMENT", g.Goexperiment)
+	}
+	if g.Cpus > 0 {
+		set("GOMAXPROCS", strconv.Itoa(g.Cpus))
+	}
+	if g.MemoryMB > 0 {
+		set("GOMEMLIMIT", fmt.Sprintf("%dMiB", g.MemoryMB))
+	}
+	return append(env, g.Env...)
+}
+
+// Private func joining a command line, quoting each argument for a POSIX shell
+func shellJoin(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Private func quoting an argument for a POSIX shell when it needs it
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:,+@%") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	g := &Golang{
+		Tools:    []string{"golang.org/x/tools/cmd/stringer@v0.20.0"},
+		SSHKey:   &Secret{},
+		SSHHost:  "github.com",
+		Deadline: "1m0s",
+	}
+	out, err := g.Commands(context.Background(), nil, "test", []string{"-run", "Test One"}, "./...", "", "", false, false, nil, false, false, true, "coverage.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# workdir /src
+GIT_SSH_COMMAND='ssh -i /root/.ssh/id_dagger -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new'
+GOBIN=/usr/local/bin
+CGO_ENABLED=1
+# install a C compiler for cgo unless the image has one
+# mount the SSH key at /root/.ssh/id_dagger
+git config --global url.git@github.com:.insteadOf https://github.com/
+env -u GOFLAGS go install golang.org/x/tools/cmd/stringer@v0.20.0
+timeout -k 10 60 go test -coverprofile coverage.out -timeout 30s -v -race -run 'Test One' ./...
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestCommandsZig(t *testing.T) {
+	out, err := (&Golang{}).Commands(context.Background(), nil, "build", nil, "", "arm64", "linux", false, false, nil, false, true, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"CGO_ENABLED=1\n", "CC='/opt/zig/zig cc -target aarch64-linux-gnu'\n", "go build -o /out/\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Exit code of `timeout` when the command ran out of time
+const TIMEOUT_EXIT_CODE = 124
+
+// Abort build, test, lint and vulncheck runs that take longer than the deadline
+//
+// Unlike go test's -timeout this also covers compiling, downloading modules and
+// the linters, so a hung compile is killed too. The deadline applies to each
+// command and to the call as a whole, canceling the call when it's exceeded
+func (g *Golang) WithDeadline(
+	// The deadline as a Go duration, e.g. 20m or 1h30m
+	deadline string,
+) (*Golang, error) {
+	d, err := time.ParseDuration(deadline)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deadline %q: %w", deadline, err)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("deadline must be positive, got %s", deadline)
+	}
+	g.Deadline = d.String()
+	return g, nil
+}
+
+// Private func bounding a context by the deadline, if one is set
+func (g *Golang) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.Deadline == "" {
+		return ctx, func() {}
+	}
+	d, _ := time.ParseDuration(g.Deadline)
+	return context.WithTimeout(ctx, d)
+}
+
+// Private func wrapping a command so it's killed when the deadline passes
+func (g *Golang) deadlineCommand(command []string) []string {
+	if g.Deadline == "" {
+		return command
+	}
+	d, _ := time.ParseDuration(g.Deadline)
+	// Round up, as timeout 0 would disable the timeout
+	seconds := strconv.Itoa(int(math.Ceil(d.Seconds())))
+	// Give the command 10s to exit after SIGTERM before killing it
+	return append([]string{"timeout", "-k", "10", seconds}, command...)
+}
+
+// Private func explaining errors caused by the deadline passing
+func (g *Golang) deadlineError(err error) error {
+	if err == nil || g.Deadline == "" {
+		return err
+	}
+	var execErr *ExecError
+	if errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &execErr) && execErr.ExitCode == TIMEOUT_EXIT_CODE) {
+		return fmt.Errorf("deadline of %s exceeded: %w", g.Deadline, err)
+	}
+	return err
+}
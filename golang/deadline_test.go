@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeadlineCommand(t *testing.T) {
+	tests := []struct {
+		deadline string
+		want     []string
+	}{
+		{"", []string{"go", "test"}},
+		{"20m0s", []string{"timeout", "-k", "10", "1200", "go", "test"}},
+		{"1.5s", []string{"timeout", "-k", "10", "2", "go", "test"}},
+		// Rounded up rather than to timeout 0, which never times out
+		{"500ms", []string{"timeout", "-k", "10", "1", "go", "test"}},
+	}
+	for _, tt := range tests {
+		g := &Golang{Deadline: tt.deadline}
+		if got := g.deadlineCommand([]string{"go", "test"}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("deadlineCommand() with %q = %q, want %q", tt.deadline, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// Port the pkgsite documentation server listens on
+	DOC_PORT = 8080
+	// The pkgsite release to install. It needs a newer Go than the default
+	// image, which GOTOOLCHAIN=auto downloads for the install only
+	PKGSITE_VERSION = "v0.1.0"
+)
+
+// Mirrors the documentation of the module path in $1 from the pkgsite URL in $2
+const MIRROR_DOCS = `wget --mirror --page-requisites --adjust-extension --convert-links --no-host-directories --no-verbose \
+  --retry-connrefused --waitretry 2 --tries 30 --include-directories "/$1,/static,/third_party" \
+  --directory-prefix /site "$2"
+code=$?
+# 8 means a linked page errored, e.g. a link out of the module
+[ $code -eq 0 ] || [ $code -eq 8 ] || exit $code
+[ -n "$(ls -A /site 2>/dev/null)" ] || { echo "pkgsite served no documentation for $1" >&2; exit 1; }`
+
+// Show the documentation for a package or symbol with `go doc`
+func (g *Golang) Doc(
+	ctx context.Context,
+	// The Go source code to document
+	// +optional
+	source *Directory,
+	// The package or symbol, e.g. ./internal/server or net/http.Client
+	// +optional
+	target string,
+	// Show the documentation for everything in the package, with -all
+	// +optional
+	all bool,
+	// Include unexported symbols, with -u
+	// +optional
+	unexported bool,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	command := []string{"go", "doc"}
+	if all {
+		command = append(command, "-all")
+	}
+	if unexported {
+		command = append(command, "-u")
+	}
+	if target != "" {
+		command = append(command, target)
+	}
+	return ctr.WithExec(command).Stdout(ctx)
+}
+
+// Serve the project's documentation as HTML with pkgsite on port 8080
+//
+// Use `dagger up` or bind the service to browse it
+func (g *Golang) DocServer(
+	ctx context.Context,
+	// The Go source code to document
+	// +optional
+	source *Directory,
+) (*Service, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctr.
+		WithExec([]string{"env", "-u", "GOFLAGS", "GOTOOLCHAIN=auto", "go", "install", "golang.org/x/pkgsite/cmd/pkgsite@" + PKGSITE_VERSION}).
+		WithExposedPort(DOC_PORT).
+		WithExec([]string{"sh", "-c", fmt.Sprintf(`bin="$(go env GOBIN)"; exec "${bin:-$(go env GOPATH)/bin}/pkgsite" -http 0.0.0.0:%d .`, DOC_PORT)}).
+		AsService(), nil
+}
+
+// Generate the project's documentation as a static HTML site
+//
+// The pages pkgsite serves for the module are mirrored, with links rewritten
+// to work from the files, e.g. to publish them or attach them to a release.
+// Open <module path>.html in the result
+func (g *Golang) DocSite(
+	ctx context.Context,
+	// The Go source code to document
+	// +optional
+	source *Directory,
+) (*Directory, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	module, err := g.ModulePath(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := g.DocServer(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.from("alpine:3.18").
+		WithExec([]string{"apk", "add", "--no-cache", "wget"}).
+		WithServiceBinding("docs", docs).
+		WithExec([]string{"sh", "-c", MIRROR_DOCS, "sh", module, fmt.Sprintf("http://docs:%d/%s", DOC_PORT, module)}).
+		Directory("/site"), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of failure, wrapped by the errors returned from methods so callers in
+// Go can check them with errors.Is. Across the Dagger API only the message is
+// kept, which starts with the kind
+var (
+	ErrBuildFailed = errors.New("build failed")
+	ErrTestFailed  = errors.New("tests failed")
+	ErrToolInstall = errors.New("tool install failed")
+	ErrVulnDB      = errors.New("vulnerability database unavailable")
+)
+
+// Private func wrapping an error as kind, naming the failed command when the
+// error came from an exec. The exec error itself carries the captured output
+func commandError(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	var execErr *ExecError
+	if !errors.As(err, &execErr) {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return fmt.Errorf("%w: `%s` exited with code %d: %w", kind, strings.Join(execErr.Cmd, " "), execErr.ExitCode, err)
+}
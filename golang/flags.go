@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+)
+
+// Add -trimpath and extra -ldflags to `go build` arguments, merging them
+// with any the user already passed so flags aren't duplicated or overridden
+func buildArgs(args []string, trimpath bool, ldflags ...string) []string {
+	out := append([]string{}, args...)
+
+	if trimpath && !hasFlag(out, "trimpath") {
+		out = append([]string{"-trimpath"}, out...)
+	}
+	if len(ldflags) == 0 {
+		return out
+	}
+
+	for i, arg := range out {
+		name, value, inline := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "ldflags" {
+			continue
+		}
+		if inline {
+			out[i] = "-ldflags=" + mergeLdflags(value, ldflags)
+		} else if i+1 < len(out) {
+			out[i+1] = mergeLdflags(out[i+1], ldflags)
+		}
+		return out
+	}
+	return append([]string{"-ldflags=" + strings.Join(ldflags, " ")}, out...)
+}
+
+// Append linker flags to an existing -ldflags value, skipping any already present
+func mergeLdflags(existing string, extra []string) string {
+	present := map[string]bool{}
+	for _, f := range strings.Fields(existing) {
+		present[f] = true
+	}
+
+	merged := existing
+	for _, f := range extra {
+		if present[f] {
+			continue
+		}
+		if merged != "" {
+			merged += " "
+		}
+		merged += f
+	}
+	return merged
+}
+
+// Whether a boolean-style flag was passed, as -name, --name or -name=value
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flag, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a flag was passed with a specific value, as -name=value or -name value
+func hasFlagValue(args []string, name, value string) bool {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flag, v, inline := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if flag != name {
+			continue
+		}
+		if !inline && i+1 < len(args) {
+			v = args[i+1]
+		}
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove a flag passed as -name=value or -name value
+func withoutFlag(args []string, name string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		flag, _, inline := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || flag != name {
+			out = append(out, arg)
+			continue
+		}
+		if !inline {
+			// Skip the separate value too
+			i++
+		}
+	}
+	return out
+}
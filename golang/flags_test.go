@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		trimpath bool
+		ldflags  []string
+		want     []string
+	}{
+		{"unchanged", []string{"./cmd/app"}, false, nil, []string{"./cmd/app"}},
+		{"trimpath", []string{"./cmd/app"}, true, nil, []string{"-trimpath", "./cmd/app"}},
+		{"trimpath already set", []string{"-trimpath", "./cmd/app"}, true, nil, []string{"-trimpath", "./cmd/app"}},
+		{"new ldflags", []string{"./cmd/app"}, false, []string{"-s", "-w"}, []string{"-ldflags=-s -w", "./cmd/app"}},
+		{"merge inline ldflags", []string{"-ldflags=-X main.v=1 -s", "./cmd/app"}, false, []string{"-s", "-w"}, []string{"-ldflags=-X main.v=1 -s -w", "./cmd/app"}},
+		{"merge separate ldflags", []string{"-ldflags", "-X main.v=1", "."}, false, []string{"-w"}, []string{"-ldflags", "-X main.v=1 -w", "."}},
+		{"double dash ldflags", []string{"--ldflags=-s"}, false, []string{"-s", "-w"}, []string{"-ldflags=-s -w"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{}, tt.args...)
+			if got := buildArgs(tt.args, tt.trimpath, tt.ldflags...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildArgs() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.args, args) {
+				t.Errorf("buildArgs() modified its input: %q", tt.args)
+			}
+		})
+	}
+}
+
+func TestFlagHelpers(t *testing.T) {
+	args := []string{"-mod=vendor", "-tags", "integration", "--race"}
+	if !hasFlag(args, "race") || hasFlag(args, "trimpath") {
+		t.Error("hasFlag() didn't match the passed flags")
+	}
+	if !hasFlagValue(args, "mod", "vendor") || !hasFlagValue(args, "tags", "integration") || hasFlagValue(args, "mod", "mod") {
+		t.Error("hasFlagValue() didn't match the passed values")
+	}
+	if got, want := withoutFlag(args, "tags"), []string{"-mod=vendor", "--race"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutFlag(tags) = %q, want %q", got, want)
+	}
+	if got, want := withoutFlag(args, "mod"), []string{"-tags", "integration", "--race"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutFlag(mod) = %q, want %q", got, want)
+	}
+}
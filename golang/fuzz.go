@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// The outcome of a fuzzing run
+type FuzzResult struct {
+	// Whether the fuzzer ran for the whole fuzztime without finding a failure
+	Passed bool
+	// Output of go test
+	Output string
+	// The package's testdata/fuzz directory, including any failing inputs found
+	Corpus *Directory
+}
+
+// Run a fuzz target with go test -fuzz
+//
+// Failing inputs are written to the package's testdata/fuzz directory, returned
+// as the corpus so they can be committed and the failure reproduced with go test
+func (g *Golang) Fuzz(
+	ctx context.Context,
+	// The Go source code to fuzz
+	// +optional
+	source *Directory,
+	// The package containing the fuzz target, e.g. ./parser
+	component string,
+	// The fuzz target, e.g. FuzzParse
+	target string,
+	// How long to fuzz for, as a duration or a number of iterations like 1000x
+	// +optional
+	// +default="30s"
+	fuzztime string,
+) (*FuzzResult, error) {
+	if strings.Contains(component, "...") {
+		return nil, fmt.Errorf("fuzzing needs a single package, got %s", component)
+	}
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	corpus := path.Join(g.workdir(), component, "testdata", "fuzz")
+	// Keep the exit code rather than failing so the corpus can still be returned
+	script := `go test -run='^$' -fuzz="^$1\$" -fuzztime="$2" "$3" 2>&1; echo $? >/tmp/fuzz.code; mkdir -p "$4"`
+	ctr = ctr.WithExec(g.deadlineCommand([]string{"sh", "-c", script, "sh", target, fuzztime, component, corpus}))
+
+	out, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	code, err := ctr.File("/tmp/fuzz.code").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &FuzzResult{
+		Passed: strings.TrimSpace(code) == "0",
+		Output: out,
+		Corpus: ctr.Directory(corpus),
+	}, nil
+}
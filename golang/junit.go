@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Where the go test -json events, stderr and exit code are written in the container
+const (
+	TEST_JSON   = "/tmp/test.json"
+	TEST_STDERR = "/tmp/test.stderr"
+	TEST_EXIT   = "/tmp/test.exit"
+)
+
+// Run the tests and report the results as JUnit XML
+//
+// Packages that fail to build are reported as a failed [build failed] testcase
+// so they show up in the report. The report is returned even when tests fail
+func (g *Golang) JUnit(
+	ctx context.Context,
+	// The Go source code to test
+	// +optional
+	source *Directory,
+	// Packages to test
+	// +optional
+	// +default="./..."
+	component string,
+) (*File, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failing run still produces the events, so ignore the exit code
+	script := fmt.Sprintf(`go test -json -timeout 30s "$@" >%s 2>%s; true`, TEST_JSON, TEST_STDERR)
+	ctr = ctr.WithExec(g.deadlineCommand([]string{"sh", "-c", script, "sh", component}))
+	events, err := ctr.File(TEST_JSON).Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := ctr.File(TEST_STDERR).Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := junitReport(events, stderr)
+	if err != nil {
+		return nil, err
+	}
+	return dag.Directory().WithNewFile("junit.xml", report).File("junit.xml"), nil
+}
+
+// A single event from go test -json
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+	// Set instead of Package on build-output and build-fail events, from Go 1.24
+	ImportPath string
+	// The ImportPath of the build that made a package fail, from Go 1.24
+	FailedBuild string
+}
+
+type junitSuites struct {
+	XMLName xml.Name      `xml:"testsuites"`
+	Suites  []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+
+	cases       map[string]*junitCase
+	output      strings.Builder
+	failed      bool
+	failedBuild string
+}
+
+type junitCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+
+	output strings.Builder
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Convert go test -json events into a JUnit XML report
+func junitReport(events, stderr string) (string, error) {
+	report := &junitSuites{}
+	suites := map[string]*junitSuite{}
+	suite := func(pkg string) *junitSuite {
+		s, ok := suites[pkg]
+		if !ok {
+			s = &junitSuite{Name: pkg, Time: "0", cases: map[string]*junitCase{}}
+			suites[pkg] = s
+			report.Suites = append(report.Suites, s)
+		}
+		return s
+	}
+
+	// Compiler output by build ImportPath, e.g. "pkg [pkg.test]"
+	builds := map[string]*strings.Builder{}
+	for _, line := range strings.Split(events, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			// Packages that fail before running print a plain FAIL line instead of events
+			if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "FAIL" && strings.HasSuffix(line, "failed]") {
+				s := suite(fields[1])
+				s.failed = true
+				fmt.Fprintln(&s.output, line)
+			}
+			continue
+		}
+		var e testEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return "", fmt.Errorf("unable to parse go test event %q: %w", line, err)
+		}
+
+		switch e.Action {
+		case "build-output":
+			b, ok := builds[e.ImportPath]
+			if !ok {
+				b = &strings.Builder{}
+				builds[e.ImportPath] = b
+			}
+			b.WriteString(e.Output)
+			continue
+		case "build-fail":
+			continue
+		}
+
+		s := suite(e.Package)
+		if e.Test == "" {
+			switch e.Action {
+			case "output":
+				s.output.WriteString(e.Output)
+			case "fail":
+				s.failed = true
+				s.failedBuild = e.FailedBuild
+				s.Time = seconds(e.Elapsed)
+			case "pass", "skip":
+				s.Time = seconds(e.Elapsed)
+			}
+			continue
+		}
+
+		c, ok := s.cases[e.Test]
+		if !ok {
+			c = &junitCase{Classname: e.Package, Name: e.Test, Time: "0"}
+			s.cases[e.Test] = c
+			s.Cases = append(s.Cases, c)
+		}
+		switch e.Action {
+		case "output":
+			c.output.WriteString(e.Output)
+		case "fail":
+			c.Time = seconds(e.Elapsed)
+			c.Failure = &junitMessage{Message: "failed", Text: c.output.String()}
+		case "skip":
+			c.Time = seconds(e.Elapsed)
+			c.Skipped = &junitMessage{Message: "skipped", Text: c.output.String()}
+		case "pass":
+			c.Time = seconds(e.Elapsed)
+			c.SystemOut = c.output.String()
+		}
+	}
+	if len(report.Suites) == 0 && strings.TrimSpace(stderr) != "" {
+		return "", fmt.Errorf("go test didn't run\n%s", stderr)
+	}
+
+	for _, s := range report.Suites {
+		caseFailed := false
+		for _, c := range s.Cases {
+			switch {
+			case c.Failure != nil:
+				caseFailed = true
+				s.Failures++
+			case c.Skipped != nil:
+				s.Skipped++
+			}
+		}
+		// A package failing without a failed test didn't build or couldn't run its tests
+		if s.failed && !caseFailed {
+			build := buildOutput(builds, s) + stderrFor(stderr, s.Name)
+			output := strings.TrimSpace(strings.TrimSpace(s.output.String()) + "\n" + build)
+			s.Cases = append(s.Cases, &junitCase{
+				Classname: s.Name,
+				Name:      "[build failed]",
+				Time:      s.Time,
+				Failure:   &junitMessage{Message: "package failed to build or run", Text: output},
+			})
+			s.Failures++
+		}
+		s.Tests = len(s.Cases)
+	}
+
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(out) + "\n", nil
+}
+
+// The compiler output of the build that failed a package, from the Go 1.24
+// build-output events. Older versions write it to stderr instead
+func buildOutput(builds map[string]*strings.Builder, s *junitSuite) string {
+	if b, ok := builds[s.failedBuild]; ok {
+		return b.String()
+	}
+	for importPath, b := range builds {
+		// The package itself or its test variant, "pkg [pkg.test]"
+		if pkg, _, _ := strings.Cut(importPath, " "); pkg == s.Name {
+			return b.String()
+		}
+	}
+	return ""
+}
+
+// The stderr lines from the section headed "# pkg", where go reports build errors
+func stderrFor(stderr, pkg string) string {
+	var out strings.Builder
+	in := false
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			header := strings.TrimPrefix(line, "# ")
+			in = header == pkg || strings.HasPrefix(header, pkg+" ")
+		}
+		if in {
+			fmt.Fprintln(&out, line)
+		}
+	}
+	return out.String()
+}
+
+// Format a duration in seconds as JUnit expects
+func seconds(elapsed float64) string {
+	return fmt.Sprintf("%.3f", elapsed)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// Captured from go test -json with Go 1.24, where build errors are events
+const buildFailEvents = `{"ImportPath":"example.com/app/broken [example.com/app/broken.test]","Action":"build-output","Output":"# example.com/app/broken [example.com/app/broken.test]\n"}
+{"ImportPath":"example.com/app/broken [example.com/app/broken.test]","Action":"build-output","Output":"broken/broken.go:5:2: undefined: missing\n"}
+{"ImportPath":"example.com/app/broken [example.com/app/broken.test]","Action":"build-fail"}
+{"Time":"2025-03-01T10:00:00Z","Action":"start","Package":"example.com/app/broken"}
+{"Time":"2025-03-01T10:00:00Z","Action":"output","Package":"example.com/app/broken","Output":"FAIL\texample.com/app/broken [build failed]\n"}
+{"Time":"2025-03-01T10:00:00Z","Action":"fail","Package":"example.com/app/broken","Elapsed":0,"FailedBuild":"example.com/app/broken [example.com/app/broken.test]"}
+{"Time":"2025-03-01T10:00:00Z","Action":"start","Package":"example.com/app/ok"}
+{"Time":"2025-03-01T10:00:00Z","Action":"run","Package":"example.com/app/ok","Test":"TestOK"}
+{"Time":"2025-03-01T10:00:00Z","Action":"output","Package":"example.com/app/ok","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Time":"2025-03-01T10:00:00Z","Action":"pass","Package":"example.com/app/ok","Test":"TestOK","Elapsed":0.01}
+{"Time":"2025-03-01T10:00:00Z","Action":"pass","Package":"example.com/app/ok","Elapsed":0.02}
+`
+
+func TestJUnitReportBuildFailed(t *testing.T) {
+	out, err := junitReport(buildFailEvents, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report junitSuites
+	if err := xml.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Suites) != 2 {
+		t.Fatalf("got %d suites, want 2", len(report.Suites))
+	}
+	for _, s := range report.Suites {
+		if s.Name == "" {
+			t.Errorf("suite with an empty name")
+		}
+	}
+
+	broken := report.Suites[0]
+	if broken.Name != "example.com/app/broken" || broken.Failures != 1 || len(broken.Cases) != 1 {
+		t.Fatalf("unexpected suite %s with %d failures and %d cases", broken.Name, broken.Failures, len(broken.Cases))
+	}
+	failure := broken.Cases[0].Failure
+	if failure == nil || !strings.Contains(failure.Text, "undefined: missing") {
+		t.Errorf("build failure doesn't include the compiler output: %+v", failure)
+	}
+
+	ok := report.Suites[1]
+	if ok.Failures != 0 || ok.Tests != 1 {
+		t.Errorf("got %d failures and %d tests for %s, want 0 and 1", ok.Failures, ok.Tests, ok.Name)
+	}
+}
+
+func TestJUnitReportBuildFailedStderr(t *testing.T) {
+	// Before Go 1.24 the compiler output goes to stderr
+	events := `{"Action":"start","Package":"example.com/app/broken"}
+FAIL	example.com/app/broken [build failed]
+`
+	stderr := "# example.com/app/broken\nbroken/broken.go:5:2: undefined: missing\n"
+	out, err := junitReport(events, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "undefined: missing") {
+		t.Errorf("report doesn't include the compiler output from stderr:\n%s", out)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rearrange the binaries from Build into the layout they should be exported with
+//
+// Each mapping is name=destination, e.g. server=bin/app-server, relative to the
+// returned directory. Binaries without a mapping are left out
+func (g *Golang) Layout(
+	ctx context.Context,
+	// The output of Build
+	build *Directory,
+	// Mappings of binary name to destination path, as name=destination
+	mappings []string,
+) (*Directory, error) {
+	entries, err := build.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := dag.Directory()
+	sources := map[string]string{}
+	for _, mapping := range mappings {
+		name, dest, ok := strings.Cut(mapping, "=")
+		if !ok || name == "" || dest == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected name=destination", mapping)
+		}
+		if !contains(entries, name) {
+			return nil, fmt.Errorf("no binary named %s in the build output, found %s", name, strings.Join(entries, ", "))
+		}
+		dest = path.Clean(dest)
+		if path.IsAbs(dest) || dest == "." || dest == ".." || strings.HasPrefix(dest, "../") {
+			return nil, fmt.Errorf("destination %s for %s must be a relative path inside the output", dest, name)
+		}
+		if other, ok := sources[dest]; ok {
+			return nil, fmt.Errorf("binaries %s and %s are both mapped to %s", other, name, dest)
+		}
+		sources[dest] = name
+
+		out = out.WithFile(dest, build.File(name))
+	}
+	return out, nil
+}
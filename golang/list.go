@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Go package as reported by `go list`
+type Package struct {
+	// The import path of the package
+	ImportPath string
+	// The package name
+	Name string
+	// The package directory, relative to the working directory
+	Dir string
+	// The packages imported directly
+	Imports []string
+	// All transitive dependencies
+	Deps []string
+	// The packages imported by the _test.go files in the package
+	TestImports []string
+	// The packages imported by the package <name>_test files
+	XTestImports []string
+	// The .go source files, excluding tests
+	GoFiles []string
+	// The _test.go files in the package
+	TestGoFiles []string
+	// The _test.go files outside the package, in package <name>_test
+	XTestGoFiles []string
+	// The //go:embed patterns in the package's source files
+	EmbedPatterns []string
+	// The files matched by the //go:embed patterns
+	EmbedFiles []string
+	// The files matched by //go:embed patterns in the _test.go files
+	TestEmbedFiles []string
+	// The files matched by //go:embed patterns in the package <name>_test files
+	XTestEmbedFiles []string
+	// Why the package couldn't be loaded, e.g. excluded by build constraints
+	Error string
+}
+
+// The fields of `go list -json` output used to build a Package
+type goListPackage struct {
+	ImportPath      string
+	Name            string
+	Dir             string
+	Imports         []string
+	Deps            []string
+	TestImports     []string
+	XTestImports    []string
+	GoFiles         []string
+	TestGoFiles     []string
+	XTestGoFiles    []string
+	EmbedPatterns   []string
+	EmbedFiles      []string
+	TestEmbedFiles  []string
+	XTestEmbedFiles []string
+	Error           *struct {
+		Err string
+	}
+}
+
+// List the packages in the project
+//
+// Packages that fail to load, such as those excluded by build constraints,
+// are still listed with their Error set rather than failing the call
+func (g *Golang) List(
+	ctx context.Context,
+	// The Go source code to list
+	// +optional
+	source *Directory,
+	// The package pattern to list
+	// +optional
+	// +default="./..."
+	component string,
+) ([]*Package, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	if component == "" {
+		component = "./..."
+	}
+
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ctr.
+		WithExec([]string{"go", "list", "-e", "-json", component}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*Package
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var p goListPackage
+		err := dec.Decode(&p)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse go list output: %w", err)
+		}
+
+		pkg := &Package{
+			ImportPath:      p.ImportPath,
+			Name:            p.Name,
+			Dir:             g.relativeDir(p.Dir),
+			Imports:         p.Imports,
+			Deps:            p.Deps,
+			TestImports:     p.TestImports,
+			XTestImports:    p.XTestImports,
+			GoFiles:         p.GoFiles,
+			TestGoFiles:     p.TestGoFiles,
+			XTestGoFiles:    p.XTestGoFiles,
+			EmbedPatterns:   p.EmbedPatterns,
+			EmbedFiles:      p.EmbedFiles,
+			TestEmbedFiles:  p.TestEmbedFiles,
+			XTestEmbedFiles: p.XTestEmbedFiles,
+		}
+		if p.Error != nil {
+			pkg.Error = p.Error.Err
+		}
+		packages = append(packages, pkg)
+	}
+	return packages, nil
+}
+
+// Private func listing the import paths of the loadable packages matching a pattern
+func (g *Golang) packages(ctx context.Context, pattern string) ([]string, error) {
+	pkgs, err := g.loadablePackages(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, pkg := range pkgs {
+		paths = append(paths, pkg.ImportPath)
+	}
+	return paths, nil
+}
+
+// Private func listing the directories of the loadable packages matching a
+// pattern, relative to the working directory
+func (g *Golang) packageDirs(ctx context.Context, pattern string) ([]string, error) {
+	pkgs, err := g.loadablePackages(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, pkg := range pkgs {
+		dirs = append(dirs, pkg.Dir)
+	}
+	return dirs, nil
+}
+
+// Private func listing the import paths of the packages matching a pattern to
+// test one at a time, including those that fail to load
+//
+// Testing a package that doesn't load fails with go test's report of why,
+// only packages excluded by build constraints are left out
+func (g *Golang) testPackages(ctx context.Context, pattern string) ([]string, error) {
+	pkgs, err := g.List(ctx, nil, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, pkg := range pkgs {
+		if !excludedByConstraints(pkg) {
+			paths = append(paths, pkg.ImportPath)
+		}
+	}
+	return paths, nil
+}
+
+// Private func listing the packages matching a pattern that load
+func (g *Golang) loadablePackages(ctx context.Context, pattern string) ([]*Package, error) {
+	pkgs, err := g.List(ctx, nil, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return loadable(pkgs)
+}
+
+// Private func keeping the packages that loaded, skipping those excluded by
+// build constraints
+//
+// Any other load error, like a syntax error or a missing import, fails rather
+// than leaving the package out of what's tested or linted
+func loadable(pkgs []*Package) ([]*Package, error) {
+	var loaded []*Package
+	var broken []string
+	for _, pkg := range pkgs {
+		switch {
+		case pkg.Error == "":
+			loaded = append(loaded, pkg)
+		case excludedByConstraints(pkg):
+		default:
+			broken = append(broken, fmt.Sprintf("%s: %s", pkg.ImportPath, pkg.Error))
+		}
+	}
+	if len(broken) > 0 {
+		return nil, fmt.Errorf("%d packages failed to load\n%s", len(broken), strings.Join(broken, "\n"))
+	}
+	return loaded, nil
+}
+
+// Private func reporting whether a package failed to load only because build
+// constraints exclude all its files, e.g. for another GOOS
+func excludedByConstraints(pkg *Package) bool {
+	return strings.Contains(pkg.Error, "build constraints exclude all Go files")
+}
+
+// A directory in the container relative to the working directory, e.g. ./pkg/foo
+func (g *Golang) relativeDir(dir string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(dir, g.workdir()), "/")
+	if rel == "" {
+		return "."
+	}
+	return "./" + rel
+}
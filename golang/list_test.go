@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadable(t *testing.T) {
+	pkgs := []*Package{
+		{ImportPath: "example.com/a"},
+		{ImportPath: "example.com/windows", Error: "build constraints exclude all Go files in /src/windows"},
+		{ImportPath: "example.com/b"},
+	}
+	loaded, err := loadable(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].ImportPath != "example.com/a" || loaded[1].ImportPath != "example.com/b" {
+		t.Errorf("expected a and b to load, got %v", loaded)
+	}
+
+	broken := append(pkgs, &Package{ImportPath: "example.com/c", Error: "c.go:3:1: syntax error: non-declaration statement outside function body"})
+	_, err = loadable(broken)
+	if err == nil || !strings.Contains(err.Error(), "example.com/c: c.go:3:1: syntax error") {
+		t.Errorf("expected the syntax error to fail the load, got %v", err)
+	}
+}
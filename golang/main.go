@@ -6,9 +6,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"path"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -16,13 +24,139 @@ const (
 	PROJ_MOUNT = "/src"
 	LINT_IMAGE = "golangci/golangci-lint:v1.58.0"
 	OUT_DIR    = "/out/"
+	GOENV_PATH = "/etc/go/env"
+)
+
+// The --out-format values supported by golangci-lint
+var LINT_FORMATS = []string{
+	"colored-line-number", "line-number", "json", "colored-tab", "tab", "checkstyle",
+	"code-climate", "html", "junit-xml", "github-actions", "teamcity",
+}
+
+const (
+	MOD_CACHE   = "/go/pkg/mod"
+	BUILD_CACHE = "/root/.cache/go-build"
+	TMPFS_DIR   = "/tmpfs"
+)
+
+const (
+	VULNDB_URL          = "https://vuln.go.dev/vulndb.zip"
+	VULNDB_MOUNT        = "/vulndb"
+	GOVULNCHECK_VERSION = "v1.1.1"
 )
 
+// Make sure a C compiler is available for cgo, installing one if possible
+const ENSURE_CC = `command -v "$(go env CC)" >/dev/null 2>&1 && exit 0
+if command -v apk >/dev/null 2>&1; then apk add --no-cache gcc musl-dev && exit 0; fi
+if command -v apt-get >/dev/null 2>&1; then apt-get update && apt-get install -y --no-install-recommends gcc libc6-dev && exit 0; fi
+echo "cgo requires a C compiler but none was found and one could not be installed, use a golang image that ships gcc" >&2
+exit 1`
+
+// Downloads the dependencies from go.mod and go.sum, leaving failures to the
+// go command run with the full source
+const DOWNLOAD_MODULES = "go mod download || echo 'go mod download failed, retrying with the full source' >&2"
+
 type Golang struct {
 	// +private
 	Ctr *Container
 	// +private
 	Proj *Directory
+	// +private
+	GoVersion string
+	// +private
+	Env []*EnvVar
+	// +private
+	SecretEnv []*SecretVar
+	// +private
+	Workdir string
+	// +private
+	Tmpfs bool
+	// +private
+	Mounts []*DirMount
+	// +private
+	FileMounts []*FileMount
+	// +private
+	CACerts []*File
+	// +private
+	SSHKey *Secret
+	// +private
+	SSHHost string
+	// +private
+	GoEnv *File
+	// +private
+	Goflags string
+	// +private
+	Goexperiment string
+	// +private
+	Cpus int
+	// +private
+	MemoryMB int
+	// +private
+	VersionPkg string
+	// +private
+	AppVersion string
+	// +private
+	Commit string
+	// +private
+	Date string
+	// +private
+	VersionVar string
+	// +private
+	CommitVar string
+	// +private
+	DateVar string
+	// +private
+	NoCache bool
+	// +private
+	ModCache string
+	// +private
+	BuildCache string
+	// +private
+	CombinedOutput bool
+	// +private
+	Tools []string
+	// +private
+	Deadline string
+	// +private
+	RegistryPort int
+	// +private
+	Services []*ServiceBinding
+	// +private
+	ReadyTimeout int
+	// +private
+	TraceEndpoint string
+	// +private
+	TraceService string
+	// +private
+	Mirror string
+	// +private
+	MirrorUsername string
+	// +private
+	MirrorPassword *Secret
+}
+
+// An environment variable set on the prepared container
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// An environment variable backed by a Secret
+type SecretVar struct {
+	Name   string
+	Secret *Secret
+}
+
+// A directory mounted into the prepared container
+type DirMount struct {
+	Path string
+	Dir  *Directory
+}
+
+// A file mounted into the prepared container
+type FileMount struct {
+	Path string
+	File *File
 }
 
 func New(
@@ -31,7 +165,7 @@ func New(
 	// +optional
 	proj *Directory,
 ) *Golang {
-	g := &Golang{}
+	g := &Golang{GoVersion: DEFAULT_GO}
 	if ctr == nil {
 		ctr = g.Base(DEFAULT_GO).Ctr
 	}
@@ -58,24 +192,287 @@ func (g *Golang) Build(
 	// The operating system for GOOS
 	// +optional
 	os string,
-) *Directory {
+	// Cross-compile cgo code using zig as the C toolchain
+	// +optional
+	useZig bool,
+	// Remove file system paths from the binary with -trimpath
+	// +optional
+	trimpath bool,
+	// Strip the symbol table and DWARF debug info with -ldflags "-s -w"
+	// +optional
+	stripSymbols bool,
+	// Main packages to build, e.g. ./cmd/server, each into a binary named after its directory
+	//
+	// When empty args decide what is built
+	// +optional
+	packages []string,
+	// Build byte-for-byte reproducible binaries
+	//
+	// Applies -trimpath, -buildvcs=false, -ldflags -buildid= and a fixed
+	// SOURCE_DATE_EPOCH. The binaries no longer record the VCS revision shown by
+	// `go version -m`, so stamp versions with -ldflags -X instead. cgo builds are
+	// only reproducible if the C toolchain is too
+	// +optional
+	reproducible bool,
+) (*Directory, error) {
 	if arch == "" {
 		arch = runtime.GOARCH
 	}
 	if os == "" {
 		os = runtime.GOOS
 	}
+	if err := g.checkPlatform(ctx, os, arch); err != nil {
+		return nil, err
+	}
 
 	if source != nil {
 		g = g.WithProject(source)
 	}
+	ctx, cancel := g.withDeadline(ctx)
+	defer cancel()
 
-	command := append([]string{"go", "build", "-o", OUT_DIR}, args...)
-	return g.prepare(ctx).
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, g.deadlineError(err)
+	}
+	ctr = ctr.
 		WithEnvVariable("GOARCH", arch).
-		WithEnvVariable("GOOS", os).
+		WithEnvVariable("GOOS", os)
+	if useZig {
+		ctr, err = g.withZig(ctr, os, arch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if reproducible {
+		ctr = ctr.WithEnvVariable("SOURCE_DATE_EPOCH", "0")
+	}
+	commands, err := g.buildCommands(args, os, trimpath, stripSymbols, packages, reproducible)
+	if err != nil {
+		return nil, err
+	}
+	for _, command := range commands {
+		ctr = ctr.WithExec(g.deadlineCommand(command))
+	}
+	dir := ctr.Directory(OUT_DIR)
+	if g.TraceEndpoint != "" || g.Deadline != "" {
+		// Evaluate the build inside the span so its duration is recorded and
+		// the deadline bounds the whole call, not only each command
+		_, err := g.span(ctx, "build", func(ctx context.Context) (string, error) {
+			_, err := dir.Sync(ctx)
+			return "", commandError(ErrBuildFailed, err)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dir, nil
+}
+
+// Private func returning the `go build` command lines, one for each package
+func (g *Golang) buildCommands(args []string, os string, trimpath, stripSymbols bool, packages []string, reproducible bool) ([][]string, error) {
+	ldflags := g.versionLdflags()
+	if stripSymbols {
+		ldflags = append(ldflags, "-s", "-w")
+	}
+	if reproducible {
+		trimpath = true
+		ldflags = append(ldflags, "-buildid=")
+		if !hasFlag(args, "buildvcs") {
+			args = append([]string{"-buildvcs=false"}, args...)
+		}
+	}
+	flags := buildArgs(args, trimpath, ldflags...)
+	if len(packages) == 0 {
+		return [][]string{append([]string{"go", "build", "-o", OUT_DIR}, flags...)}, nil
+	}
+
+	var commands [][]string
+	binaries := map[string]string{}
+	for _, pkg := range packages {
+		if strings.Contains(pkg, "...") {
+			return nil, fmt.Errorf("package %s is a pattern, list each main package to build", pkg)
+		}
+		name := path.Base(pkg)
+		if name == "." || name == "/" {
+			return nil, fmt.Errorf("package %s has no directory to name the binary after, build it with args instead", pkg)
+		}
+		if os == "windows" {
+			name += ".exe"
+		}
+		if other, ok := binaries[name]; ok {
+			return nil, fmt.Errorf("packages %s and %s would both be built as %s", other, pkg, name)
+		}
+		binaries[name] = pkg
+
+		command := append([]string{"go", "build", "-o", OUT_DIR + name}, flags...)
+		commands = append(commands, append(command, pkg))
+	}
+	return commands, nil
+}
+
+// Download the module dependencies into the module cache
+//
+// Chain this before Build, Test or lint to fail early on dependency
+// resolution errors, separately from compiling
+func (g *Golang) Download(
+	ctx context.Context,
+	// The Go source code to download dependencies for
+	// +optional
+	source *Directory,
+) (*Golang, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctr, err = ctr.
+		WithExec([]string{"go", "mod", "download", "-x"}).
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download module dependencies: %w", err)
+	}
+	g.Ctr = ctr
+	return g, nil
+}
+
+// Populate the vendor directory with `go mod vendor`, returning it
+//
+// With verify set this also runs `go mod verify` and fails if the vendor
+// directory committed in the project differs from the generated one
+func (g *Golang) Vendor(
+	ctx context.Context,
+	// The Go source code to vendor
+	// +optional
+	source *Directory,
+	// Fail if the committed vendor directory is out of date
+	// +optional
+	verify bool,
+) (*Directory, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// In a workspace the vendor directory is shared, next to go.work
+	work, err := g.workspaceFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	command := []string{"go", "mod", "vendor"}
+	vendorDir := path.Join(g.Workdir, "vendor")
+	if work != "" {
+		command = []string{"go", "work", "vendor"}
+		vendorDir = path.Join(path.Dir(work), "vendor")
+	}
+
+	// A module without dependencies produces no vendor directory
+	vendor := ctr.
 		WithExec(command).
-		Directory(OUT_DIR)
+		WithExec([]string{"mkdir", "-p", path.Join(PROJ_MOUNT, vendorDir)}).
+		Directory(path.Join(PROJ_MOUNT, vendorDir))
+	if !verify {
+		return vendor, nil
+	}
+
+	if _, err := ctr.WithExec([]string{"go", "mod", "verify"}).Sync(ctx); err != nil {
+		return nil, fmt.Errorf("module verification failed: %w", err)
+	}
+	src, err := g.projectSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	committed := src.Directory(vendorDir)
+	if _, err := committed.Sync(ctx); err != nil {
+		// Nothing committed yet, compare against an empty vendor directory
+		committed = dag.Directory()
+	}
+	diff, err := g.Diff(ctx, committed, vendor)
+	if err != nil {
+		return nil, err
+	}
+	if diff.Changed {
+		return nil, fmt.Errorf("vendor directory is out of date, run `go mod vendor`\n%s", diff.Diff)
+	}
+	return vendor, nil
+}
+
+// Check each release binary has a debug variant and is smaller than it
+const COMPARE_VARIANTS = `for bin in release/*; do
+  name=${bin#release/}
+  [ -f "debug/$name" ] || { echo "debug/$name is missing" >&2; exit 1; }
+  r=$(wc -c < "$bin"); d=$(wc -c < "debug/$name")
+  if [ "$r" -ge "$d" ]; then
+    echo "release/$name ($r bytes) isn't smaller than debug/$name ($d bytes), symbols weren't stripped" >&2
+    exit 1
+  fi
+  echo "$name: release $r bytes, debug $d bytes"
+done`
+
+// Build both a stripped release binary and a binary with full debug symbols
+//
+// The variants are written to the release/ and debug/ subdirectories of the
+// returned Directory and are built from the same source in the same container,
+// with the same flags apart from -s -w. The call fails if a release binary
+// isn't smaller than its debug variant
+func (g *Golang) BuildVariants(
+	ctx context.Context,
+	// The Go source code to build
+	// +optional
+	source *Directory,
+	// Arguments to `go build`
+	// +optional
+	args []string,
+	// The architecture for GOARCH
+	// +optional
+	arch string,
+	// The operating system for GOOS
+	// +optional
+	os string,
+) (*Directory, error) {
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	if os == "" {
+		os = runtime.GOOS
+	}
+	if err := g.checkPlatform(ctx, os, arch); err != nil {
+		return nil, err
+	}
+
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.
+		WithEnvVariable("GOARCH", arch).
+		WithEnvVariable("GOOS", os)
+
+	// The variants only differ by -s -w, which drops the symbol table and DWARF
+	// without changing the code, so addresses from the release binary resolve
+	// against the debug one
+	release := append([]string{"go", "build", "-o", OUT_DIR + "release/"}, buildArgs(args, true, append(g.versionLdflags(), "-s", "-w")...)...)
+	debug := append([]string{"go", "build", "-o", OUT_DIR + "debug/"}, buildArgs(args, true, g.versionLdflags()...)...)
+	built := ctr.
+		WithExec(g.deadlineCommand(release)).
+		WithExec(g.deadlineCommand(debug)).
+		WithWorkdir(OUT_DIR).
+		WithExec([]string{"sh", "-c", COMPARE_VARIANTS})
+	if _, err := built.Sync(ctx); err != nil {
+		return nil, commandError(ErrBuildFailed, err)
+	}
+	return built.Directory(OUT_DIR), nil
 }
 
 // Build a Go project returning a Container containing the build
@@ -96,13 +493,16 @@ func (g *Golang) BuildContainer(
 	// Base container in which to copy the build
 	// +optional
 	base *Container,
-) *Container {
-	dir := g.Build(ctx, source, args, arch, os)
+) (*Container, error) {
+	dir, err := g.Build(ctx, source, args, arch, os, false, false, false, nil, false)
+	if err != nil {
+		return nil, err
+	}
 	if base == nil {
-		base = dag.Container().From("ubuntu:latest")
+		base = g.from("ubuntu:latest")
 	}
 	return base.
-		WithDirectory("/usr/local/bin/", dir)
+		WithDirectory("/usr/local/bin/", dir), nil
 }
 
 // Test the Go project
@@ -113,124 +513,1123 @@ func (g *Golang) Test(
 	source *Directory,
 	// Arguments to `go test`
 	// +optional
-	// +default "./..."
+	// +default="./..."
 	component string,
 	// Generate a coverprofile or not at a location
 	// +optional
-	// +default ./
+	// +default="coverage.out"
 	coverageLocation string,
+	// Run the tests with the race detector, this requires cgo
+	// +optional
+	race bool,
+	// The shard of the packages to test, from 0 to shardTotal-1
+	// +optional
+	shardIndex int,
+	// Split the packages into this many shards and only test one of them
+	//
+	// Packages are assigned to shards by a hash of their import path, so a
+	// package always lands on the same shard
+	// +optional
+	shardTotal int,
+	// Extra flags for `go test`, e.g. -count=1, -run or -parallel
+	// +optional
+	extraArgs []string,
+	// Stop a package's tests after the first failure, with -failfast
+	//
+	// Other packages keep running, as go test runs packages independently
+	// +optional
+	failFast bool,
+	// Test one package at a time and stop at the first failing package
+	//
+	// Implies failFast. Packages no longer run in parallel, so a full run is
+	// slower, but a failure is reported without waiting for the rest
+	// +optional
+	failFastPackages bool,
+	// Re-run failed tests up to this many times before failing
+	//
+	// Tests that pass on a retry are listed as flaky at the end of the output.
+	// Can't be combined with failFastPackages
+	// +optional
+	retries int,
 ) (string, error) {
 	if source != nil {
 		g = g.WithProject(source)
 	}
+	if err := checkShard(shardIndex, shardTotal); err != nil {
+		return "", err
+	}
+	if retries > 0 && failFastPackages {
+		// Retries re-run the failed tests of every package together
+		return "", fmt.Errorf("retries can't be combined with failFastPackages, set one or the other")
+	}
+	if failFast || failFastPackages {
+		extraArgs = append([]string{"-failfast"}, extraArgs...)
+	}
+
+	packages := []string{component}
+	if failFastPackages && shardTotal == 0 {
+		all, err := g.testPackages(ctx, component)
+		if err != nil {
+			return "", err
+		}
+		packages = all
+	}
+	if shardTotal > 0 {
+		all, err := g.packages(ctx, component)
+		if err != nil {
+			return "", err
+		}
+		packages = shard(all, shardIndex, shardTotal)
+		if len(packages) == 0 {
+			return fmt.Sprintf("no packages in shard %d of %d", shardIndex, shardTotal), nil
+		}
+	}
 
-	command := append([]string{"go", "test", component, "-coverprofile", coverageLocation, "-timeout", "30s", "-v"})
+	if retries > 0 {
+		return g.span(ctx, "test", func(ctx context.Context) (string, error) {
+			return g.testWithRetries(ctx, packages, coverageLocation, race, extraArgs, retries)
+		})
+	}
+	if failFastPackages {
+		return g.span(ctx, "test", func(ctx context.Context) (string, error) {
+			var out strings.Builder
+			for i, pkg := range packages {
+				ctr, err := g.testContainer(ctx, []string{pkg}, coverageLocation, race, extraArgs)
+				if err != nil {
+					return "", err
+				}
+				pkgOut, err := g.output(ctx, ctr)
+				if err != nil {
+					return "", fmt.Errorf("stopped after %s failed, %d packages not tested: %w", pkg, len(packages)-i-1, commandError(ErrTestFailed, err))
+				}
+				out.WriteString(pkgOut)
+			}
+			return out.String(), nil
+		})
+	}
 
-	return g.prepare(ctx).WithExec(command).Stdout(ctx)
+	return g.span(ctx, "test", func(ctx context.Context) (string, error) {
+		ctr, err := g.testContainer(ctx, packages, coverageLocation, race, extraArgs)
+		if err != nil {
+			return "", err
+		}
+		out, err := g.output(ctx, ctr)
+		return out, commandError(ErrTestFailed, err)
+	})
 }
 
-func (g *Golang) Attach(
-	ctx context.Context,
-	container *Container,
-) (*Container, error) {
-	dockerd := g.Service("24.0")
+// The packages assigned to a shard
+func shard(packages []string, index, total int) []string {
+	var selected []string
+	for _, pkg := range packages {
+		h := fnv.New32a()
+		h.Write([]byte(pkg))
+		if int(h.Sum32()%uint32(total)) == index {
+			selected = append(selected, pkg)
+		}
+	}
+	return selected
+}
 
-	dockerHost, err := dockerd.Endpoint(ctx, ServiceEndpointOpts{
-		Scheme: "tcp",
-	})
+// Run a package's tests, streaming the verbose output to the Dagger logs as it's produced
+//
+// Useful for finding a hanging test. The output is written to stderr while the
+// tests run, and the package results are returned once they finish
+func (g *Golang) TestStream(
+	ctx context.Context,
+	// The Go source code to test
+	// +optional
+	source *Directory,
+	// The package to test, e.g. ./internal/server
+	component string,
+	// The go test -timeout, after which the stuck goroutines are dumped
+	// +optional
+	// +default="10m"
+	timeout string,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return container.
-		WithServiceBinding("docker", dockerd).
-		WithEnvVariable("DOCKER_HOST", dockerHost), nil
+	// Tee the output to stderr for the logs, keeping it and the exit code to report
+	script := `{ go test -v -count=1 -timeout "$1" "$2" 2>&1; echo $? >/tmp/test.code; } | tee /tmp/test.log >&2
+exit "$(cat /tmp/test.code)"`
+	return g.span(ctx, "test", func(ctx context.Context) (string, error) {
+		ctr := ctr.WithExec(g.deadlineCommand([]string{"sh", "-c", script, "sh", timeout, component}))
+		if _, err := ctr.Sync(ctx); err != nil {
+			var execErr *ExecError
+			if errors.As(err, &execErr) {
+				return "", fmt.Errorf("%w\n%s", ErrTestFailed, testSummary(execErr.Stderr))
+			}
+			return "", err
+		}
+		output, err := ctr.File("/tmp/test.log").Contents(ctx)
+		if err != nil {
+			return "", err
+		}
+		return testSummary(output), nil
+	})
 }
 
-// Get a Service container running dockerd
-func (g *Golang) Service(
-	// +optional
-	// +default="24.0"
-	dockerVersion string,
-) *Service {
-	port := 2375
-	return dag.Container().
-		From(fmt.Sprintf("docker:%s-dind", dockerVersion)).
-		WithMountedCache(
-			"/var/lib/docker",
-			dag.CacheVolume(dockerVersion+"-docker-lib"),
-			ContainerWithMountedCacheOpts{
-				Sharing: Private,
-			}).
-		WithExposedPort(port).
-		WithExec([]string{
-			"dockerd",
-			"--host=tcp://0.0.0.0:2375",
-			"--host=unix:///var/run/docker.sock",
-			"--tls=false",
-		}, ContainerWithExecOpts{
-			InsecureRootCapabilities: true,
-		}).
-		AsService()
+// The result lines of go test -v output, dropping the logs of passing tests
+func testSummary(out string) string {
+	var summary strings.Builder
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		for _, prefix := range []string{"--- FAIL", "FAIL", "ok", "panic:", "PASS"} {
+			if strings.HasPrefix(trimmed, prefix) {
+				fmt.Fprintln(&summary, line)
+				break
+			}
+		}
+	}
+	return summary.String()
 }
 
-func (g *Golang) Vulncheck(
+// Run only the runnable examples, checking their output matches the // Output comments
+func (g *Golang) Examples(
 	ctx context.Context,
-	// The Go source code to lint
+	// The Go source code to test
 	// +optional
 	source *Directory,
-	// Workdir to run golangci-lint
+	// Packages to run the examples of
 	// +optional
-	// +default "./..."
+	// +default="./..."
 	component string,
 ) (string, error) {
 	if source != nil {
 		g = g.WithProject(source)
 	}
-	g.Ctr = g.prepare(ctx).WithExec([]string{"go", "install", "golang.org/x/vuln/cmd/govulncheck@latest"})
-	// return g.prepare().WithExec([]string{"ls", "-latr", component}).Stdout(ctx)
-	return g.prepare(ctx).WithExec([]string{"govulncheck", "-C", component}).Stdout(ctx)
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return g.span(ctx, "examples", func(ctx context.Context) (string, error) {
+		command := []string{"go", "test", "-run", "^Example", "-v", component}
+		out, err := g.output(ctx, ctr.WithExec(g.deadlineCommand(command)))
+		var execErr *ExecError
+		if errors.As(err, &execErr) {
+			return "", fmt.Errorf("examples failed\n%s%s", execErr.Stdout, execErr.Stderr)
+		}
+		return out, err
+	})
 }
 
-// Lint the Go project
-func (g *Golang) GolangciLint(
+// Repeatedly run the tests until one fails or the time budget runs out
+//
+// Intended for hunting rare flakes, each iteration bypasses the test cache
+func (g *Golang) Soak(
 	ctx context.Context,
-	// The Go source code to lint
+	// The Go source code to test
 	// +optional
 	source *Directory,
-	// Workdir to run golangci-lint
+	// Arguments to `go test`
 	// +optional
-	// +default "./..."
+	// +default="./..."
 	component string,
+	// How long to keep re-running the tests for
+	// +optional
+	// +default=30
+	maxMinutes int,
 ) (string, error) {
 	if source != nil {
 		g = g.WithProject(source)
 	}
-	return dag.Container().From(LINT_IMAGE).
-		WithMountedDirectory("/src", g.Proj).
-		WithWorkdir("/src").
-		WithExec([]string{"golangci-lint", "run", "-v", "--allow-parallel-runners", component, "--timeout", "5m"}).
-		Stdout(ctx)
+
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(time.Duration(maxMinutes) * time.Minute)
+	passed := 0
+	for time.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		_, err := ctr.
+			// Vary the exec so the engine doesn't serve a cached result
+			WithEnvVariable("SOAK_ITERATION", strconv.Itoa(passed+1)).
+			WithExec([]string{"go", "test", component, "-count=1", "-v"}).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed after %d passing iterations: %w", passed, commandError(ErrTestFailed, err))
+		}
+		passed++
+	}
+
+	return fmt.Sprintf("%d iterations passed in %d minutes with no failures", passed, maxMinutes), nil
 }
 
-// Sets up the Container with a golang image and cache volumes
-func (g *Golang) Base(version string) *Golang {
-	mod := dag.CacheVolume("gomodcache")
-	build := dag.CacheVolume("gobuildcache")
-	image := fmt.Sprintf("golang:%s", version)
-	c := dag.Container().
-		From(image).
-		WithMountedCache("/go/pkg/mod", mod).
-		WithMountedCache("/root/.cache/go-build", build)
-	g.Ctr = c
-	return g
+// The outcome of running the tests with one Go version
+type VersionResult struct {
+	// The Go version the tests ran with
+	Version string
+	// Whether the tests passed
+	Passed bool
+	// Output of the test run, or the failure
+	Output string
 }
 
-// The go build container
+// Run the tests against each Go version
+//
+// Every version's result is returned, check Passed to see which failed. Set
+// failOnError to fail the call instead when any version fails
+func (g *Golang) TestMatrix(
+	ctx context.Context,
+	// The Go source code to test
+	// +optional
+	source *Directory,
+	// The Go versions to test with, e.g. 1.22 and 1.23
+	versions []string,
+	// Arguments to `go test`
+	// +optional
+	// +default="./..."
+	component string,
+	// Fail with a summary of every version when any of them fails
+	// +optional
+	failOnError bool,
+) ([]*VersionResult, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	var eg errgroup.Group
+	results := make([]*VersionResult, len(versions))
+	for i, version := range versions {
+		i, version := i, version
+		eg.Go(func() error {
+			result := &VersionResult{Version: version}
+			results[i] = result
+
+			gv := *g
+			v, err := gv.WithGoVersion(ctx, version)
+			if err != nil {
+				result.Output = err.Error()
+				return nil
+			}
+			out, err := v.Test(ctx, nil, component, "coverage.out", false, 0, 0, nil, false, false, 0)
+			if err != nil {
+				result.Output = err.Error()
+				return nil
+			}
+			result.Passed = true
+			result.Output = out
+			return nil
+		})
+	}
+	eg.Wait()
+	if !failOnError {
+		return results, nil
+	}
+
+	failed := false
+	var summary strings.Builder
+	for _, r := range results {
+		status := "passed"
+		if !r.Passed {
+			status = "failed"
+			failed = true
+		}
+		fmt.Fprintf(&summary, "== go %s: %s\n%s\n", r.Version, status, r.Output)
+	}
+	if failed {
+		return nil, fmt.Errorf("tests failed for one or more Go versions\n%s", summary.String())
+	}
+	return results, nil
+}
+
+// Test the Go project returning the coverprofile
+func (g *Golang) Coverage(
+	ctx context.Context,
+	// The Go source code to test
+	// +optional
+	source *Directory,
+	// Arguments to `go test`
+	// +optional
+	// +default="./..."
+	component string,
+	// Location of the coverprofile, relative to the project
+	// +optional
+	// +default="coverage.out"
+	coverageLocation string,
+) (*File, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	if coverageLocation == "" {
+		coverageLocation = "coverage.out"
+	}
+	if !path.IsAbs(coverageLocation) {
+		coverageLocation = path.Join(g.workdir(), coverageLocation)
+	}
+	ctr, err := g.testContainer(ctx, []string{component}, coverageLocation, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ctr.File(coverageLocation), nil
+}
+
+// Run the tests with -coverpkg and return one coverage profile merged across packages
+//
+// Each package's coverage then includes the statements exercised by the tests
+// of other packages, which raises the numbers of integration-style suites.
+// Every test binary is instrumented for all the packages matching coverpkg, so
+// on large modules builds are slower and the profile larger than with Coverage
+func (g *Golang) CoverageMerged(
+	ctx context.Context,
+	// The Go source code to test
+	// +optional
+	source *Directory,
+	// Packages to test
+	// +optional
+	// +default="./..."
+	component string,
+	// The packages to measure coverage of, passed to -coverpkg
+	// +optional
+	// +default="./..."
+	coverpkg string,
+) (*File, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	if coverpkg == "" {
+		coverpkg = "./..."
+	}
+
+	coverageLocation := path.Join(g.workdir(), "coverage.out")
+	ctr, err := g.testContainer(ctx, []string{component}, coverageLocation, false, []string{"-coverpkg", coverpkg})
+	if err != nil {
+		return nil, err
+	}
+	profile, err := ctr.File(coverageLocation).Contents(ctx)
+	if err != nil {
+		return nil, commandError(ErrTestFailed, err)
+	}
+	return dag.Directory().
+		WithNewFile("coverage.out", mergeProfile(profile)).
+		File("coverage.out"), nil
+}
+
+// Private func merging the blocks each test binary reported for the same
+// statements, summing the counts or for set mode keeping whether any ran
+func mergeProfile(profile string) string {
+	lines := strings.Split(strings.TrimSpace(profile), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "mode: ") {
+		return profile
+	}
+	mode := strings.TrimPrefix(lines[0], "mode: ")
+
+	var blocks []string
+	counts := map[string]int{}
+	for _, line := range lines[1:] {
+		// Blocks are `file:start,end statements count`
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		block := line[:i]
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			continue
+		}
+		prev, seen := counts[block]
+		if !seen {
+			blocks = append(blocks, block)
+		}
+		if mode == "set" {
+			if count > prev {
+				counts[block] = count
+			} else {
+				counts[block] = prev
+			}
+		} else {
+			counts[block] = prev + count
+		}
+	}
+
+	var merged strings.Builder
+	merged.WriteString(lines[0] + "\n")
+	for _, block := range blocks {
+		fmt.Fprintf(&merged, "%s %d\n", block, counts[block])
+	}
+	return merged.String()
+}
+
+// Run the tests and return the total statement coverage percentage, e.g. 84.3
+//
+// The percentage is returned as a string since Dagger functions can't return floats
+func (g *Golang) CoveragePercent(
+	ctx context.Context,
+	// The Go source code to test
+	// +optional
+	source *Directory,
+	// Packages to test
+	// +optional
+	// +default="./..."
+	component string,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	profile := path.Join(g.workdir(), "coverage.out")
+	ctr, err := g.testContainer(ctx, []string{component}, profile, false, nil)
+	if err != nil {
+		return "", err
+	}
+	data, err := ctr.File(profile).Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	// A profile with only its mode line means no statements were covered or instrumented
+	if len(strings.Fields(data)) <= 2 {
+		return "", fmt.Errorf("no coverage data was produced, do the packages have tests?")
+	}
+	out, err := ctr.WithExec([]string{"go", "tool", "cover", "-func", profile}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	return totalCoverage(out)
+}
+
+// Parse the total percentage from `go tool cover -func` output
+func totalCoverage(out string) (string, error) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "total:" {
+			continue
+		}
+		percent := strings.TrimSuffix(fields[len(fields)-1], "%")
+		if _, err := strconv.ParseFloat(percent, 64); err != nil {
+			return "", fmt.Errorf("unable to parse total coverage %q", fields[len(fields)-1])
+		}
+		return percent, nil
+	}
+	return "", fmt.Errorf("no total in the coverage report")
+}
+
+// Private func returning the container after running the tests
+func (g *Golang) testContainer(ctx context.Context, packages []string, coverageLocation string, race bool, extraArgs []string) (*Container, error) {
+	ctr, err := g.testBase(ctx, race)
+	if err != nil {
+		return nil, err
+	}
+	return ctr.WithExec(g.deadlineCommand(testCommand(packages, coverageLocation, race, extraArgs))), nil
+}
+
+// Private func returning the prepared container for running the tests
+func (g *Golang) testBase(ctx context.Context, race bool) (*Container, error) {
+	base := *g
+	if race {
+		// The race detector needs cgo, install the compiler before mounting
+		// the project so it's cached independently of source changes
+		base.Ctr = base.Ctr.
+			WithEnvVariable("CGO_ENABLED", "1").
+			WithExec([]string{"sh", "-c", ENSURE_CC})
+	}
+
+	return base.prepare(ctx)
+}
+
+// Private func checking the shard options select a shard, or no sharding at all
+func checkShard(index, total int) error {
+	if total < 0 {
+		return fmt.Errorf("shardTotal must be positive, got %d", total)
+	}
+	if total == 0 {
+		if index != 0 {
+			return fmt.Errorf("shardIndex %d is set without shardTotal", index)
+		}
+		return nil
+	}
+	if index < 0 || index >= total {
+		return fmt.Errorf("shardIndex must be between 0 and %d, got %d", total-1, index)
+	}
+	return nil
+}
+
+// Private func returning the `go test` command line
+func testCommand(packages []string, coverageLocation string, race bool, extraArgs []string) []string {
+	if coverageLocation == "" {
+		coverageLocation = "coverage.out"
+	}
+	command := []string{"go", "test", "-coverprofile", coverageLocation, "-timeout", "30s"}
+	if !hasFlag(extraArgs, "json") {
+		// -json already reports every test
+		command = append(command, "-v")
+	}
+	if race {
+		command = append(command, "-race")
+	}
+	// Flags come before the package patterns, later flags override the defaults
+	command = append(command, extraArgs...)
+	return append(command, packages...)
+}
+
+func (g *Golang) Attach(
+	ctx context.Context,
+	container *Container,
+) (*Container, error) {
+	dockerd := g.Service("24.0")
+
+	dockerHost, err := dockerd.Endpoint(ctx, ServiceEndpointOpts{
+		Scheme: "tcp",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	container = container.
+		WithServiceBinding("docker", dockerd).
+		WithEnvVariable("DOCKER_HOST", dockerHost)
+	container, err = g.waitReady(ctx, container, "dockerd", dockerProbe(dockerHost))
+	if err != nil {
+		return nil, err
+	}
+	if addr := g.registryAddr(); addr != "" {
+		container = container.
+			WithServiceBinding(REGISTRY_HOST, g.RegistryService(g.RegistryPort)).
+			WithEnvVariable("REGISTRY_ADDR", addr)
+	}
+	return container, nil
+}
+
+// Get a Service container running dockerd
+func (g *Golang) Service(
+	// +optional
+	// +default="24.0"
+	dockerVersion string,
+) *Service {
+	port := 2375
+	command := []string{
+		"dockerd",
+		"--host=tcp://0.0.0.0:2375",
+		"--host=unix:///var/run/docker.sock",
+		"--tls=false",
+	}
+	ctr := g.from(fmt.Sprintf("docker:%s-dind", dockerVersion))
+	if addr := g.registryAddr(); addr != "" {
+		// Allow pushing to the local registry over plain HTTP
+		command = append(command, "--insecure-registry="+addr)
+		ctr = ctr.WithServiceBinding(REGISTRY_HOST, g.RegistryService(g.RegistryPort))
+	}
+	return ctr.
+		WithMountedCache(
+			"/var/lib/docker",
+			dag.CacheVolume(dockerVersion+"-docker-lib"),
+			ContainerWithMountedCacheOpts{
+				Sharing: Private,
+			}).
+		WithExposedPort(port).
+		WithExec(command, ContainerWithExecOpts{
+			InsecureRootCapabilities: true,
+		}).
+		AsService()
+}
+
+func (g *Golang) Vulncheck(
+	ctx context.Context,
+	// The Go source code to lint
+	// +optional
+	source *Directory,
+	// Workdir to run golangci-lint
+	// +optional
+	// +default "./..."
+	component string,
+	// A pre-downloaded vulnerability database, as extracted from vuln.go.dev/vulndb.zip
+	//
+	// When set nothing is fetched over the network: govulncheck is installed
+	// from the module cache and the project's dependencies must be cached or
+	// vendored, e.g. by an earlier online run of Vulncheck or Download
+	// +optional
+	localDB *Directory,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	install := []string{"env", "-u", "GOFLAGS", "go", "install", "golang.org/x/vuln/cmd/govulncheck@" + GOVULNCHECK_VERSION}
+	if localDB != nil {
+		// Set before prepare so the module download can't reach the network either
+		offline := *g
+		offline.Env = append(append([]*EnvVar{}, g.Env...),
+			&EnvVar{Name: "GOPROXY", Value: "off"},
+			&EnvVar{Name: "GOSUMDB", Value: "off"},
+			&EnvVar{Name: "GOTOOLCHAIN", Value: "local"},
+		)
+		g = &offline
+		install = []string{"env", "GOFLAGS=-mod=mod", "go", "install", "golang.org/x/vuln/cmd/govulncheck@" + GOVULNCHECK_VERSION}
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+	ctr = ctr.WithExec(install)
+	if _, err := ctr.Sync(ctx); err != nil {
+		if localDB != nil {
+			return "", fmt.Errorf("govulncheck %s isn't in the module cache, run Vulncheck once online first: %w", GOVULNCHECK_VERSION, commandError(ErrToolInstall, err))
+		}
+		return "", fmt.Errorf("unable to install govulncheck %s: %w", GOVULNCHECK_VERSION, commandError(ErrToolInstall, err))
+	}
+
+	if localDB != nil {
+		ctr = ctr.WithMountedDirectory(VULNDB_MOUNT, localDB)
+	} else {
+		if err := g.refreshVulnDB(ctx); err != nil {
+			return "", err
+		}
+		ctr = ctr.WithMountedCache(VULNDB_MOUNT, dag.CacheVolume("govulndb"))
+	}
+
+	return g.span(ctx, "vulncheck", func(ctx context.Context) (string, error) {
+		return g.output(ctx, ctr.WithExec(g.deadlineCommand([]string{"govulncheck", "-db", "file://" + VULNDB_MOUNT, "-C", component})))
+	})
+}
+
+// Run go vet on the Go project
+func (g *Golang) Vet(
+	ctx context.Context,
+	// The Go source code to vet
+	// +optional
+	source *Directory,
+	// Packages to vet
+	// +optional
+	// +default="./..."
+	component string,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	out, err := g.vet(ctx, component)
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		return "", fmt.Errorf("go vet found issues\n%s", execErr.Stdout)
+	}
+	return out, err
+}
+
+// Run staticcheck on the Go project
+//
+// Checks are configured by the project's staticcheck.conf files, or overridden with checks
+func (g *Golang) Staticcheck(
+	ctx context.Context,
+	// The Go source code to check
+	// +optional
+	source *Directory,
+	// Packages to check
+	// +optional
+	// +default="./..."
+	component string,
+	// The staticcheck version to install, e.g. 2023.1.7
+	// +optional
+	// +default="latest"
+	version string,
+	// The checks to run, e.g. all,-ST1000, instead of the configured ones
+	// +optional
+	checks string,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+	ctr = ctr.WithExec([]string{"env", "-u", "GOFLAGS", "go", "install", "honnef.co/go/tools/cmd/staticcheck@" + version})
+	if _, err := ctr.Sync(ctx); err != nil {
+		return "", fmt.Errorf("unable to install staticcheck %s: %w", version, commandError(ErrToolInstall, err))
+	}
+
+	command := []string{"staticcheck"}
+	if checks != "" {
+		command = append(command, "-checks", checks)
+	}
+	return g.span(ctx, "staticcheck", func(ctx context.Context) (string, error) {
+		out, err := g.output(ctx, ctr.WithExec(g.deadlineCommand(append(command, component))))
+		var execErr *ExecError
+		if errors.As(err, &execErr) && execErr.Stdout != "" {
+			return "", fmt.Errorf("staticcheck found issues\n%s", execErr.Stdout)
+		}
+		return out, err
+	})
+}
+
+// Private func running go vet, with its findings written to stdout
+func (g *Golang) vet(ctx context.Context, component string) (string, error) {
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+	return g.span(ctx, "vet", func(ctx context.Context) (string, error) {
+		command := []string{"sh", "-c", `go vet "$@" 2>&1`, "sh", component}
+		return ctr.WithExec(g.deadlineCommand(command)).Stdout(ctx)
+	})
+}
+
+// Download the vulnerability database into its cache volume at most once a day,
+// falling back to the cached copy when the network is unavailable
+func (g *Golang) refreshVulnDB(ctx context.Context) error {
+	script := `if wget -q -T 30 -O /tmp/vulndb.zip "$1"; then
+  rm -rf "$2"/* && unzip -q -o /tmp/vulndb.zip -d "$2"
+elif [ -f "$2/index/db.json" ]; then
+  echo "unable to refresh the vulnerability database, using the cached copy" >&2
+else
+  echo "unable to download the vulnerability database from $1 and no cached copy exists, pass a localDB to run offline" >&2
+  exit 1
+fi`
+	_, err := g.from("alpine:3.18").
+		WithMountedCache(VULNDB_MOUNT, dag.CacheVolume("govulndb"), ContainerWithMountedCacheOpts{
+			Sharing: Locked,
+		}).
+		// Only invalidate the download once a day
+		WithEnvVariable("VULNDB_DATE", time.Now().UTC().Format("2006-01-02")).
+		WithExec([]string{"sh", "-c", script, "sh", VULNDB_URL, VULNDB_MOUNT}).
+		Sync(ctx)
+	return commandError(ErrVulnDB, err)
+}
+
+// Lint the Go project
+func (g *Golang) GolangciLint(
+	ctx context.Context,
+	// The Go source code to lint
+	// +optional
+	source *Directory,
+	// Workdir to run golangci-lint
+	// +optional
+	// +default "./..."
+	component string,
+	// Lint the packages in batches, each in its own container, to bound memory and time
+	//
+	// Issues that need analysis across batches may be missed
+	// +optional
+	chunked bool,
+	// The number of packages in each batch when chunked
+	// +optional
+	// +default=25
+	chunkSize int,
+	// The golangci-lint --out-format, e.g. json, checkstyle or github-actions
+	//
+	// When chunked each batch's report is written separately
+	// +optional
+	outputFormat string,
+	// Report issues without failing, with --issues-exit-code=0
+	// +optional
+	reportOnly bool,
+	// Only report issues introduced since this git revision, e.g. origin/main
+	//
+	// The source must include the .git directory with the revision in its
+	// history, so shallow clones need fetching deep enough to reach it
+	// +optional
+	newFromRev string,
+) (string, error) {
+	if outputFormat != "" && !contains(LINT_FORMATS, outputFormat) {
+		return "", fmt.Errorf("unsupported golangci-lint output format %q, valid values: %s", outputFormat, strings.Join(LINT_FORMATS, ", "))
+	}
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	lint, err := g.lintContainer(ctx)
+	if err != nil {
+		return "", err
+	}
+	flags := []string{"-v", "--allow-parallel-runners", "--timeout", "5m"}
+	if newFromRev != "" {
+		if err := checkRevision(ctx, lint, newFromRev); err != nil {
+			return "", err
+		}
+		flags = append(flags, "--new-from-rev", newFromRev)
+	}
+	if outputFormat != "" {
+		flags = append(flags, "--out-format", outputFormat)
+	}
+	if reportOnly {
+		flags = append(flags, "--issues-exit-code=0")
+	}
+	if !chunked {
+		return g.span(ctx, "lint", func(ctx context.Context) (string, error) {
+			command := append(append([]string{"golangci-lint", "run"}, flags...), component)
+			return g.output(ctx, lint.WithExec(g.deadlineCommand(command)))
+		})
+	}
+
+	if chunkSize < 1 {
+		return "", fmt.Errorf("chunkSize must be at least 1, got %d", chunkSize)
+	}
+	dirs, err := g.packageDirs(ctx, component)
+	if err != nil {
+		return "", err
+	}
+
+	return g.span(ctx, "lint", func(ctx context.Context) (string, error) {
+		failed := false
+		var merged strings.Builder
+		for start := 0; start < len(dirs); start += chunkSize {
+			end := start + chunkSize
+			if end > len(dirs) {
+				end = len(dirs)
+			}
+			command := append(append([]string{"golangci-lint", "run"}, flags...), dirs[start:end]...)
+			out, err := g.output(ctx, lint.WithExec(g.deadlineCommand(command)))
+			if err != nil {
+				// Keep linting the remaining batches, reporting every finding at the end
+				var execErr *ExecError
+				if !errors.As(err, &execErr) {
+					return "", err
+				}
+				failed = true
+				out = execErr.Stdout
+			}
+			fmt.Fprintf(&merged, "== packages %d-%d of %d\n%s", start+1, end, len(dirs), out)
+		}
+
+		if failed {
+			return "", fmt.Errorf("golangci-lint found issues\n%s", merged.String())
+		}
+		return merged.String(), nil
+	})
+}
+
+// Apply golangci-lint autofixes, returning the fixed source to export back to the project
+//
+// Issues that can't be fixed automatically don't fail the call, run
+// GolangciLint on the result to report them
+func (g *Golang) GolangciLintFix(
+	ctx context.Context,
+	// The Go source code to fix
+	// +optional
+	source *Directory,
+	// Packages to run golangci-lint on
+	// +optional
+	// +default="./..."
+	component string,
+) (*Directory, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	lint, err := g.lintContainer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// --fix edits the files in the mounted project, so return the container's
+	// copy rather than the original input
+	return lint.
+		WithExec(g.deadlineCommand([]string{"golangci-lint", "run", "--fix", "--issues-exit-code=0", "--allow-parallel-runners", component, "--timeout", "5m"})).
+		Directory(PROJ_MOUNT), nil
+}
+
+// Private func checking a git revision can be resolved in the project
+//
+// golangci-lint silently reports every issue when it can't find the revision,
+// so fail early explaining why instead
+func checkRevision(ctx context.Context, c *Container, rev string) error {
+	script := `
+if ! git rev-parse --git-dir >/dev/null 2>&1; then
+	echo "the source has no git history, include the .git directory" >&2
+	exit 1
+fi
+if ! git rev-parse --verify --quiet "$1^{commit}" >/dev/null; then
+	if [ "$(git rev-parse --is-shallow-repository)" = "true" ]; then
+		echo "revision $1 isn't in the shallow clone, fetch more history with git fetch --deepen or --unshallow" >&2
+	else
+		echo "revision $1 not found, fetch it before linting" >&2
+	fi
+	exit 1
+fi`
+	_, err := c.WithExec([]string{"sh", "-c", script, "sh", rev}).Sync(ctx)
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		return fmt.Errorf("unable to lint changes since %s: %s", rev, strings.TrimSpace(execErr.Stderr))
+	}
+	return err
+}
+
+// Private func returning the golangci-lint container with the project mounted
+func (g *Golang) lintContainer(ctx context.Context) (*Container, error) {
+	src, err := g.projectSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return g.from(LINT_IMAGE).
+		WithMountedDirectory(PROJ_MOUNT, src).
+		WithWorkdir(g.workdir()), nil
+}
+
+// Sets up the Container with a golang image and cache volumes
+func (g *Golang) Base(version string) *Golang {
+	mod := dag.CacheVolume("gomodcache")
+	build := dag.CacheVolume("gobuildcache")
+	image := fmt.Sprintf("golang:%s", version)
+	c := g.from(image)
+	if !g.NoCache {
+		c = c.
+			WithMountedCache(g.modCache(), mod).
+			WithMountedCache(g.buildCache(), build)
+	}
+	if g.ModCache != "" {
+		c = c.WithEnvVariable("GOMODCACHE", g.ModCache)
+	}
+	if g.BuildCache != "" {
+		c = c.WithEnvVariable("GOCACHE", g.BuildCache)
+	}
+	g.Ctr = c
+	g.GoVersion = version
+	return g
+}
+
+// Move the module cache, setting GOMODCACHE and mounting the cache volume there
+func (g *Golang) WithGomodcache(dir string) (*Golang, error) {
+	if !path.IsAbs(dir) {
+		return nil, fmt.Errorf("GOMODCACHE must be an absolute path, got %s", dir)
+	}
+	g.Ctr = g.Ctr.WithoutMount(g.modCache())
+	g.ModCache = path.Clean(dir)
+	g.Ctr = g.Ctr.WithEnvVariable("GOMODCACHE", g.ModCache)
+	if !g.NoCache {
+		g.Ctr = g.Ctr.WithMountedCache(g.ModCache, dag.CacheVolume("gomodcache"))
+	}
+	return g, nil
+}
+
+// Move the build cache, setting GOCACHE and mounting the cache volume there
+func (g *Golang) WithGocache(dir string) (*Golang, error) {
+	if !path.IsAbs(dir) {
+		return nil, fmt.Errorf("GOCACHE must be an absolute path, got %s", dir)
+	}
+	g.Ctr = g.Ctr.WithoutMount(g.buildCache())
+	g.BuildCache = path.Clean(dir)
+	g.Ctr = g.Ctr.WithEnvVariable("GOCACHE", g.BuildCache)
+	if !g.NoCache {
+		g.Ctr = g.Ctr.WithMountedCache(g.BuildCache, dag.CacheVolume("gobuildcache"))
+	}
+	return g, nil
+}
+
+// Private func returning where the module cache is mounted
+func (g *Golang) modCache() string {
+	if g.ModCache != "" {
+		return g.ModCache
+	}
+	return MOD_CACHE
+}
+
+// Private func returning where the build cache is mounted
+func (g *Golang) buildCache() string {
+	if g.BuildCache != "" {
+		return g.BuildCache
+	}
+	return BUILD_CACHE
+}
+
+// Export the module and build caches as a Directory, e.g. to persist them
+// between CI runs on ephemeral engines
+//
+// The result has mod/ and build/ subdirectories. Both caches can grow to several
+// GB, so saving and restoring them costs time and storage proportional to
+// their size; it pays off when downloading and compiling from scratch is slower
+func (g *Golang) ExportCache() *Directory {
+	script := fmt.Sprintf(
+		"mkdir -p /cache-export/mod /cache-export/build && cp -a %s/. /cache-export/mod/ && cp -a %s/. /cache-export/build/",
+		g.modCache(), g.buildCache(),
+	)
+	return g.Ctr.
+		// The cache contents aren't part of the exec's cache key, always re-run
+		WithEnvVariable("CACHE_EXPORT", time.Now().String()).
+		WithExec([]string{"sh", "-c", script}).
+		Directory("/cache-export")
+}
+
+// Restore module and build caches previously saved with ExportCache
+//
+// Missing or empty mod/ and build/ subdirectories are skipped
+func (g *Golang) ImportCache(dir *Directory) *Golang {
+	script := fmt.Sprintf(
+		"if [ -d /cache-import/mod ]; then cp -a /cache-import/mod/. %s/; fi && if [ -d /cache-import/build ]; then cp -a /cache-import/build/. %s/; fi",
+		g.modCache(), g.buildCache(),
+	)
+	g.Ctr = g.Ctr.
+		WithMountedDirectory("/cache-import", dir).
+		WithExec([]string{"sh", "-c", script}).
+		WithoutMount("/cache-import")
+	return g
+}
+
+// Seed the module cache from a directory, e.g. restored from a CI cache
+//
+// The directory must have the layout of GOMODCACHE, with the downloaded
+// modules under cache/download. Modules found there aren't downloaded again,
+// and go commands fall back to the network for any that are missing
+func (g *Golang) WithModuleCacheDir(dir *Directory) *Golang {
+	g.Ctr = g.Ctr.
+		WithMountedDirectory("/cache-seed", dir).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("mkdir -p %[1]s && cp -a /cache-seed/. %[1]s/", g.modCache())}).
+		WithoutMount("/cache-seed")
+	return g
+}
+
+// The version of the Go toolchain in use, e.g. 1.22.3
+//
+// When a project is set this runs in the prepared container, so a toolchain
+// selected by the project's go.mod is reported
+func (g *Golang) Version(ctx context.Context) (string, error) {
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		ctr = g.Ctr
+	}
+
+	out, err := ctr.WithExec([]string{"go", "version"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	// Output is `go version go1.22.3 linux/amd64`
+	fields := strings.Fields(out)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "go") {
+		return "", fmt.Errorf("unexpected go version output: %s", out)
+	}
+	return strings.TrimPrefix(fields[2], "go"), nil
+}
+
+// The go build container
+//
+// This is the base container without the project mounted, see Prepared for
+// the container that build/test/lint actually run in
 func (g *Golang) Container() *Container {
 	return g.Ctr
 }
 
+// The container build/test/lint run in, with the project mounted at /src,
+// the workdir set and any configured environment and services applied
+func (g *Golang) Prepared(
+	ctx context.Context,
+	// The Go source code to mount
+	// +optional
+	source *Directory,
+) (*Container, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	return g.prepare(ctx)
+}
+
+// Open an interactive terminal in the prepared container to debug failures
+func (g *Golang) Debug(
+	ctx context.Context,
+	// The Go source code to mount
+	// +optional
+	source *Directory,
+) (*Terminal, error) {
+	ctr, err := g.Prepared(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return ctr.Terminal(), nil
+}
+
 // The go project directory
 func (g *Golang) Project() *Directory {
 	return g.Ctr.Directory(PROJ_MOUNT)
@@ -242,12 +1641,313 @@ func (g *Golang) WithProject(dir *Directory) *Golang {
 	return g
 }
 
+// Switch to the golang image for a Go version, keeping the project
+//
+// This replaces any container set with WithContainer
+func (g *Golang) WithGoVersion(
+	ctx context.Context,
+	// The golang image tag, e.g. 1.23 or 1.22.5
+	version string,
+) (*Golang, error) {
+	g = g.Base(version)
+	if _, err := g.Ctr.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("unable to pull golang:%s, is it a released Go version? %w", version, err)
+	}
+	return g, nil
+}
+
+// Base the container on the Go version required by the project's go.mod
+//
+// The toolchain line is preferred over the go directive as it pins a patch
+// release. Without a project or a go.mod the default version is used
+func (g *Golang) WithGoVersionFromGoMod(
+	ctx context.Context,
+	// The Go source code containing go.mod
+	// +optional
+	source *Directory,
+) (*Golang, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	proj, err := g.projectSource(ctx)
+	if err != nil {
+		return g.WithGoVersion(ctx, DEFAULT_GO)
+	}
+	if g.Workdir != "" {
+		proj = proj.Directory(g.Workdir)
+	}
+	entries, err := proj.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(entries, "go.mod") {
+		return g.WithGoVersion(ctx, DEFAULT_GO)
+	}
+
+	gomod, err := proj.File("go.mod").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read go.mod: %w", err)
+	}
+	version := goModVersion(gomod)
+	if version == "" {
+		version = DEFAULT_GO
+	}
+	return g.WithGoVersion(ctx, version)
+}
+
+// Parse the Go version from go.mod, preferring the toolchain line, e.g. 1.22.5
+func goModVersion(gomod string) string {
+	var goVersion string
+	for _, line := range strings.Split(gomod, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "toolchain":
+			if v, ok := strings.CutPrefix(fields[1], "go"); ok {
+				return v
+			}
+		case "go":
+			goVersion = fields[1]
+		}
+	}
+	return goVersion
+}
+
+// Return the module path declared in the project's go.mod
+//
+// The go.mod is parsed directly, falling back to `go list -m` when it can't be
+func (g *Golang) ModulePath(
+	ctx context.Context,
+	// The Go source code containing go.mod
+	// +optional
+	source *Directory,
+) (string, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	proj, err := g.projectSource(ctx)
+	if err != nil {
+		return "", err
+	}
+	if gomod, err := proj.File(path.Join(g.Workdir, "go.mod")).Contents(ctx); err == nil {
+		if module := goModPath(gomod); module != "" {
+			return module, nil
+		}
+	}
+
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, err := ctr.WithExec([]string{"go", "list", "-m"}).Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the module path, does the project have a go.mod? %w", err)
+	}
+	// In a workspace every module is listed, the first is the main one
+	lines := strings.Fields(out)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("go list -m reported no module")
+	}
+	return lines[0], nil
+}
+
+// Parse the module path from go.mod's module directive
+func goModPath(gomod string) string {
+	for _, line := range strings.Split(gomod, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`")
+		}
+	}
+	return ""
+}
+
+// Whether a string is in the list
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 // Bring your own container
 func (g *Golang) WithContainer(ctr *Container) *Golang {
 	g.Ctr = ctr
 	return g
 }
 
+// Set an environment variable on the container used for build/test/lint
+func (g *Golang) WithEnv(name, value string) *Golang {
+	g.Env = append(g.Env, &EnvVar{Name: name, Value: value})
+	return g
+}
+
+// Set an environment variable from a Secret on the container used for build/test/lint
+//
+// The value is never written to the cache key or logs
+func (g *Golang) WithEnvFromSecret(name string, secret *Secret) *Golang {
+	g.SecretEnv = append(g.SecretEnv, &SecretVar{Name: name, Secret: secret})
+	return g
+}
+
+// Run build/test/lint in a subdirectory of the project, e.g. the module at services/api
+//
+// The whole project is still mounted at /src, so replace directives pointing at
+// sibling modules resolve
+func (g *Golang) WithWorkdir(subpath string) (*Golang, error) {
+	subpath = path.Clean(subpath)
+	if path.IsAbs(subpath) || subpath == ".." || strings.HasPrefix(subpath, "../") {
+		return nil, fmt.Errorf("workdir %s must be a relative path inside the project", subpath)
+	}
+	if subpath == "." {
+		subpath = ""
+	}
+	g.Workdir = subpath
+	return g, nil
+}
+
+// Private func returning the directory go commands run in
+func (g *Golang) workdir() string {
+	return path.Join(PROJ_MOUNT, g.Workdir)
+}
+
+// Point TMPDIR at a tmpfs, speeding up tests that write many temporary files
+//
+// go build's work files and anything tests create with os.TempDir or t.TempDir
+// are kept in memory rather than on the container's overlay filesystem. They
+// count against the engine host's memory until the command exits, so a suite
+// writing gigabytes of temporary data can run the host out of memory. The
+// tmpfs is mounted at /tmpfs rather than /tmp, which stays on disk
+func (g *Golang) WithTmpfs() *Golang {
+	g.Tmpfs = true
+	return g
+}
+
+// Mount an extra directory, e.g. shared test fixtures, into the container used for build/test/lint
+//
+// The path must be absolute and outside the project mounted at /src
+func (g *Golang) WithMount(path string, dir *Directory) (*Golang, error) {
+	if err := checkMountPath(path); err != nil {
+		return nil, err
+	}
+	g.Mounts = append(g.Mounts, &DirMount{Path: path, Dir: dir})
+	return g, nil
+}
+
+// Mount an extra file, e.g. a certificate, into the container used for build/test/lint
+//
+// The path must be absolute and outside the project mounted at /src
+func (g *Golang) WithFile(path string, file *File) (*Golang, error) {
+	if err := checkMountPath(path); err != nil {
+		return nil, err
+	}
+	g.FileMounts = append(g.FileMounts, &FileMount{Path: path, File: file})
+	return g, nil
+}
+
+// Private func downloading the project's dependencies from go.mod and go.sum alone
+//
+// The download runs before the rest of the source is added, so Dagger only
+// re-runs it when go.mod or go.sum change rather than on every source edit.
+// A failure, e.g. from a replace directive pointing at a local directory, is
+// left for the go command to report once the full source is there
+func (g *Golang) downloadModules(ctx context.Context, c *Container) (*Container, error) {
+	dir := g.Proj
+	if g.Workdir != "" {
+		dir = dir.Directory(g.Workdir)
+	}
+	entries, err := dir.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(entries, "go.mod") {
+		return c, nil
+	}
+	c = c.WithFile(path.Join(g.workdir(), "go.mod"), dir.File("go.mod"))
+	if contains(entries, "go.sum") {
+		c = c.WithFile(path.Join(g.workdir(), "go.sum"), dir.File("go.sum"))
+	}
+	return c.WithExec([]string{"sh", "-c", DOWNLOAD_MODULES}), nil
+}
+
+// Private func checking an extra mount doesn't clash with the project
+func checkMountPath(p string) error {
+	if !path.IsAbs(p) {
+		return fmt.Errorf("mount path %s must be absolute", p)
+	}
+	p = path.Clean(p)
+	if p == "/" || p == PROJ_MOUNT || strings.HasPrefix(p, PROJ_MOUNT+"/") {
+		return fmt.Errorf("mount path %s would overlap the project at %s", p, PROJ_MOUNT)
+	}
+	return nil
+}
+
+// Apply a go env config file, as written by `go env -w`, to the container
+//
+// Settings like GOFLAGS, GOPROXY and GOPRIVATE can be managed in one committed
+// file. Environment variables set with WithEnv take precedence over it
+func (g *Golang) WithGoEnv(file *File) *Golang {
+	g.GoEnv = file
+	return g
+}
+
+// Set GOFLAGS for every go command run in the container
+//
+// With -mod=vendor the project's vendor directory is used instead of the
+// module cache, so the project must include vendor/modules.txt
+func (g *Golang) WithGoflags(goflags string) *Golang {
+	g.Goflags = goflags
+	return g
+}
+
+// Build and test without the module and build caches, to diagnose caching issues
+//
+// Every module is downloaded and every package compiled and tested from scratch
+// on each call, which is significantly slower. It's meant for reproducing
+// "works on my cache" bugs rather than regular use
+func (g *Golang) WithoutCache() *Golang {
+	g.NoCache = true
+	g.Ctr = g.Ctr.
+		WithoutMount(g.modCache()).
+		WithoutMount(g.buildCache())
+	return g
+}
+
+// Set GOEXPERIMENT to enable experimental toolchain features, e.g. loopvar
+func (g *Golang) WithGoexperiment(experiment string) *Golang {
+	g.Goexperiment = experiment
+	return g
+}
+
+// Bound the CPU and memory used by go commands and the binaries they run
+//
+// Dagger can't set cgroup limits on a container, so the limits are applied
+// through the Go runtime: GOMAXPROCS caps parallel compiles and test execution,
+// and GOMEMLIMIT makes each Go process collect garbage harder as it nears the
+// limit. GOMEMLIMIT is a soft limit per process and doesn't cover cgo or the
+// linker's peak, so leave headroom, especially with -race. The dockerd service
+// started by Attach runs in its own container and isn't limited
+func (g *Golang) WithResources(
+	// The number of CPUs to use, 0 for no limit
+	// +optional
+	cpus int,
+	// The memory limit of each go process in MiB, 0 for no limit
+	// +optional
+	memoryMB int,
+) (*Golang, error) {
+	if cpus < 0 || memoryMB < 0 {
+		return nil, fmt.Errorf("resource limits can't be negative, got %d cpus and %dMiB", cpus, memoryMB)
+	}
+	g.Cpus = cpus
+	g.MemoryMB = memoryMB
+	return g, nil
+}
+
 // Build a remote git repo
 func (g *Golang) BuildRemote(
 	ctx context.Context,
@@ -256,11 +1956,64 @@ func (g *Golang) BuildRemote(
 	arch string,
 	// +optional
 	platform string,
-) *Directory {
-	git := dag.Git(fmt.Sprintf("https://%s", remote)).
-		Branch(ref).
-		Tree()
-	g = g.WithProject(git)
+) (*Directory, error) {
+	return g.buildRemote(ctx, remoteBranch(remote, ref).Tree(), module, arch, platform)
+}
+
+// A build of a remote git repo with the commit it was built from
+type RemoteBuild struct {
+	// The build output
+	Build *Directory
+	// The repository URL
+	Remote string
+	// The branch that was built
+	Ref string
+	// The commit SHA the branch resolved to
+	Commit string
+}
+
+// Build a remote git repo, recording the commit that was built
+//
+// The branch tip is resolved once, so the build and the reported commit
+// always match even if the branch moves
+func (g *Golang) BuildRemoteInfo(
+	ctx context.Context,
+	remote, ref, module string,
+	// +optional
+	arch string,
+	// +optional
+	platform string,
+) (*RemoteBuild, error) {
+	commit, err := remoteBranch(remote, ref).Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %s in %s: %w", ref, remote, err)
+	}
+	tree := dag.Git(remoteURL(remote)).Commit(commit).Tree()
+	dir, err := g.buildRemote(ctx, tree, module, arch, platform)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteBuild{
+		Build:  dir,
+		Remote: remoteURL(remote),
+		Ref:    ref,
+		Commit: commit,
+	}, nil
+}
+
+// The URL of a remote git repo, e.g. github.com/org/repo
+func remoteURL(remote string) string {
+	return fmt.Sprintf("https://%s", remote)
+}
+
+// A branch of a remote git repo
+func remoteBranch(remote, ref string) *GitRef {
+	return dag.Git(remoteURL(remote)).Branch(ref)
+}
+
+// Private func building a module of a checked out remote repo
+func (g *Golang) buildRemote(ctx context.Context, tree *Directory, module, arch, platform string) (*Directory, error) {
+	g = g.WithProject(tree)
 
 	if arch == "" {
 		arch = runtime.GOARCH
@@ -269,22 +2022,115 @@ func (g *Golang) BuildRemote(
 		platform = runtime.GOOS
 	}
 	command := append([]string{"go", "build", "-o", "build/"}, module)
-	return g.prepare(ctx).
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctr.
 		WithEnvVariable("GOARCH", arch).
 		WithEnvVariable("GOOS", platform).
 		WithExec(command).
-		Directory(fmt.Sprintf("%s/%s/", PROJ_MOUNT, "build"))
+		Directory(fmt.Sprintf("%s/%s/", g.workdir(), "build")), nil
 }
 
 // Private func to check readiness and prepare the container for build/test/lint
-func (g *Golang) prepare(ctx context.Context) *Container {
-	c := g.Ctr.
-		WithDirectory(PROJ_MOUNT, g.Proj).
-		WithWorkdir(PROJ_MOUNT)
+func (g *Golang) prepare(ctx context.Context) (*Container, error) {
+	c := g.Ctr
+	if g.Proj == nil {
+		// Without a project the source must already be baked into the container
+		if _, err := g.projectSource(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if g.Workdir != "" {
+		src, err := g.projectSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := src.Directory(g.Workdir).Sync(ctx); err != nil {
+			return nil, fmt.Errorf("workdir %s doesn't exist in the project: %w", g.Workdir, err)
+		}
+	}
+	c = g.withCACerts(c.WithWorkdir(g.workdir()))
+	if g.Tmpfs {
+		c = c.WithMountedTemp(TMPFS_DIR)
+	}
+	for _, m := range g.Mounts {
+		c = c.WithMountedDirectory(m.Path, m.Dir)
+	}
+	for _, m := range g.FileMounts {
+		c = c.WithMountedFile(m.Path, m.File)
+	}
+	if g.GoEnv != nil {
+		c = c.WithMountedFile(GOENV_PATH, g.GoEnv)
+	}
 
-	c, err := g.Attach(ctx, c)
+	work, err := g.workspaceFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if work == "" && g.Goflags != "" {
+		if err := g.checkVendor(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for _, env := range g.envVars(work) {
+		c = c.WithEnvVariable(env.Name, env.Value)
+	}
+	for _, env := range g.SecretEnv {
+		c = c.WithSecretVariable(env.Name, env.Secret)
+	}
+	c = g.withSSH(c)
+	// Install the tools and download modules before adding the source, so
+	// editing it doesn't re-run either
+	c, err = g.installTools(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if g.Proj != nil {
+		if work == "" && !hasFlagValue(strings.Fields(g.goflags(work)), "mod", "vendor") {
+			c, err = g.downloadModules(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c = c.WithDirectory(PROJ_MOUNT, g.Proj)
+	}
+
+	attached, err := g.Attach(ctx, c)
 	if err != nil {
 		log.Printf(err.Error())
+	} else {
+		c = attached
+	}
+	return g.bindServices(ctx, c)
+}
+
+// Private func returning the project, or the source already baked into a
+// container brought with WithContainer
+func (g *Golang) projectSource(ctx context.Context) (*Directory, error) {
+	if g.Proj != nil {
+		return g.Proj, nil
+	}
+	dir := g.Ctr.Directory(PROJ_MOUNT)
+	entries, err := dir.Entries(ctx)
+	if err != nil || len(entries) == 0 {
+		return nil, fmt.Errorf("no project set and the container has no source at %s, pass a source or call WithProject", PROJ_MOUNT)
+	}
+	return dir, nil
+}
+
+// Private func checking a vendored build has a vendor directory to build from
+func (g *Golang) checkVendor(ctx context.Context) error {
+	if !hasFlagValue(strings.Fields(g.Goflags), "mod", "vendor") {
+		return nil
+	}
+	src, err := g.projectSource(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := src.File(path.Join(g.Workdir, "vendor/modules.txt")).Sync(ctx); err != nil {
+		return fmt.Errorf("GOFLAGS sets -mod=vendor but the project has no vendor/modules.txt, run `go mod vendor` first")
 	}
-	return c
+	return nil
 }
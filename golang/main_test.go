@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    string
+	}{
+		{
+			name: "sums counts",
+			profile: `mode: count
+example.com/a/a.go:3.14,5.2 1 2
+example.com/a/a.go:7.14,9.2 1 0
+example.com/a/a.go:3.14,5.2 1 3
+`,
+			want: `mode: count
+example.com/a/a.go:3.14,5.2 1 5
+example.com/a/a.go:7.14,9.2 1 0
+`,
+		},
+		{
+			name: "set keeps whether any ran",
+			profile: `mode: set
+example.com/a/a.go:3.14,5.2 1 0
+example.com/a/a.go:3.14,5.2 1 1
+example.com/a/a.go:3.14,5.2 1 0
+`,
+			want: `mode: set
+example.com/a/a.go:3.14,5.2 1 1
+`,
+		},
+		{
+			name:    "not a profile",
+			profile: "ok  example.com/a 0.1s\n",
+			want:    "ok  example.com/a 0.1s\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeProfile(tt.profile); got != tt.want {
+				t.Errorf("mergeProfile() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTotalCoverage(t *testing.T) {
+	out := `example.com/a/a.go:3:	Hello		100.0%
+example.com/a/a.go:7:	Bye		0.0%
+total:			(statements)	84.3%
+`
+	got, err := totalCoverage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "84.3" {
+		t.Errorf("totalCoverage() = %q, want 84.3", got)
+	}
+
+	if _, err := totalCoverage("example.com/a/a.go:3:	Hello	100.0%\n"); err == nil {
+		t.Error("expected an error without a total line")
+	}
+	if _, err := totalCoverage("total:	(statements)	n/a\n"); err == nil {
+		t.Error("expected an error for an unparseable total")
+	}
+}
+
+func TestShard(t *testing.T) {
+	var packages []string
+	for _, c := range "abcdefghijklmnopqrstuvwxyz" {
+		packages = append(packages, "example.com/"+string(c))
+	}
+
+	seen := map[string]int{}
+	for index := 0; index < 4; index++ {
+		for _, pkg := range shard(packages, index, 4) {
+			seen[pkg]++
+		}
+	}
+	for _, pkg := range packages {
+		if seen[pkg] != 1 {
+			t.Errorf("%s is in %d shards, want 1", pkg, seen[pkg])
+		}
+	}
+
+	if !reflect.DeepEqual(shard(packages, 2, 4), shard(packages, 2, 4)) {
+		t.Error("shards aren't stable")
+	}
+	if got := shard(packages, 0, 1); !reflect.DeepEqual(got, packages) {
+		t.Errorf("a single shard should hold every package, got %v", got)
+	}
+}
+
+func TestCheckShard(t *testing.T) {
+	tests := []struct {
+		index, total int
+		ok           bool
+	}{
+		{0, 0, true},
+		{0, 1, true},
+		{3, 4, true},
+		{4, 4, false},
+		{-1, 4, false},
+		{0, -2, false},
+		{1, 0, false},
+	}
+	for _, tt := range tests {
+		if err := checkShard(tt.index, tt.total); (err == nil) != tt.ok {
+			t.Errorf("checkShard(%d, %d) = %v, want ok %t", tt.index, tt.total, err, tt.ok)
+		}
+	}
+}
+
+func TestGoModVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		gomod string
+		want  string
+	}{
+		{"go line", "module example.com/a\n\ngo 1.21\n", "1.21"},
+		{"toolchain wins", "module example.com/a\n\ngo 1.21\n\ntoolchain go1.22.5\n", "1.22.5"},
+		{"toolchain first", "toolchain go1.22.5\ngo 1.21\n", "1.22.5"},
+		{"none", "module example.com/a\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goModVersion(tt.gomod); got != tt.want {
+				t.Errorf("goModVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoModPath(t *testing.T) {
+	tests := []struct {
+		gomod string
+		want  string
+	}{
+		{"module example.com/a\n\ngo 1.21\n", "example.com/a"},
+		{"// comment\nmodule \"example.com/quoted\" // trailing\n", "example.com/quoted"},
+		{"go 1.21\n", ""},
+	}
+	for _, tt := range tests {
+		if got := goModPath(tt.gomod); got != tt.want {
+			t.Errorf("goModPath(%q) = %q, want %q", strings.SplitN(tt.gomod, "\n", 2)[0], got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+)
+
+// Pull Docker Hub images through a registry mirror, e.g. myregistry.internal
+//
+// Covers the golang, golangci-lint, dind and helper images, so golang:1.22 is
+// pulled as myregistry.internal/golang:1.22. Images from other registries are
+// pulled from where they are. The credentials are only used to authenticate
+// the pulls and are never written into a layer. This replaces any container
+// set with WithContainer, like WithGoVersion
+func (g *Golang) WithRegistryMirror(
+	// The mirror registry, optionally with a path prefix, e.g. myregistry.internal/dockerhub
+	mirror string,
+	// The mirror username
+	// +optional
+	username string,
+	// The mirror password or token
+	// +optional
+	password *Secret,
+) *Golang {
+	g.Mirror = strings.TrimSuffix(mirror, "/")
+	g.MirrorUsername = username
+	g.MirrorPassword = password
+	return g.Base(g.GoVersion)
+}
+
+// Private func returning a container from an image, pulled through the mirror
+// when one is set
+func (g *Golang) from(image string) *Container {
+	ctr := dag.Container()
+	repo, _ := splitRef(image)
+	if g.Mirror == "" || registryHost(repo) != "docker.io" {
+		return ctr.From(image)
+	}
+	if g.MirrorPassword != nil {
+		mirrorHost, _, _ := strings.Cut(g.Mirror, "/")
+		ctr = ctr.WithRegistryAuth(mirrorHost, g.MirrorUsername, g.MirrorPassword)
+	}
+	// Official images are library/<name> on Docker Hub but mirrored as <name>
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	return ctr.From(g.Mirror + "/" + image)
+}
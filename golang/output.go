@@ -0,0 +1,26 @@
+package main
+
+import "context"
+
+// Include stderr after stdout in the output of Test, Vet, Vulncheck, lint and the other checks
+//
+// Warnings and diagnostics written to stderr, like go vet's or golangci-lint's
+// -v logs, are otherwise dropped when a command succeeds. When a command fails
+// the error always includes both its stdout and stderr
+func (g *Golang) WithCombinedOutput() *Golang {
+	g.CombinedOutput = true
+	return g
+}
+
+// Private func returning an exec's stdout, followed by its stderr with WithCombinedOutput
+func (g *Golang) output(ctx context.Context, ctr *Container) (string, error) {
+	out, err := ctr.Stdout(ctx)
+	if err != nil || !g.CombinedOutput {
+		return out, err
+	}
+	stderr, err := ctr.Stderr(ctx)
+	if err != nil {
+		return "", err
+	}
+	return out + stderr, nil
+}
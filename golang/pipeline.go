@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// The outcome of a single stage of a pipeline
+type StageResult struct {
+	// Name of the stage
+	Name string
+	// Whether the stage was run or skipped
+	Ran bool
+	// Whether the stage succeeded, skipped stages count as passed
+	Passed bool
+	// Output of the stage, or the reason it was skipped
+	Output string
+}
+
+// Kinds of changed files, used to decide which stages need to run
+const (
+	changeDocs       = "docs"
+	changeVendor     = "vendor"
+	changeLintConfig = "lint-config"
+	changeCode       = "code"
+)
+
+// The change kinds each stage cares about
+var stageTriggers = map[string][]string{
+	"build": {changeCode, changeVendor},
+	"test":  {changeCode, changeVendor},
+	"lint":  {changeCode, changeLintConfig},
+}
+
+// Run only the build, test and lint stages relevant to the files changed since baseRef
+//
+// The source must include the .git directory so the diff can be computed
+func (g *Golang) SmartPipeline(
+	ctx context.Context,
+	// The Go source code, including its git history
+	// +optional
+	source *Directory,
+	// The git ref to diff against, e.g. origin/main
+	baseRef string,
+) ([]*StageResult, error) {
+	if source != nil {
+		g = g.WithProject(source)
+	}
+
+	changed, err := g.changedFiles(ctx, baseRef)
+	if err != nil {
+		return nil, err
+	}
+	embedded, err := g.embeddedFiles(ctx, changed)
+	if err != nil {
+		return nil, err
+	}
+	kinds := map[string]bool{}
+	for _, file := range changed {
+		kinds[classifyChange(file, embedded)] = true
+	}
+
+	stages := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"build", func() (string, error) {
+			ctr, err := g.prepare(ctx)
+			if err != nil {
+				return "", err
+			}
+			return ctr.WithExec([]string{"go", "build", "./..."}).Stdout(ctx)
+		}},
+		{"test", func() (string, error) {
+			return g.Test(ctx, nil, "./...", "coverage.out", false, 0, 0, nil, false, false, 0)
+		}},
+		{"lint", func() (string, error) {
+			return g.GolangciLint(ctx, nil, "./...", false, 0, "", false, "")
+		}},
+	}
+
+	var results []*StageResult
+	for _, stage := range stages {
+		result := &StageResult{Name: stage.name, Passed: true}
+		if !triggered(stage.name, kinds) {
+			result.Output = fmt.Sprintf("skipped: no relevant changes since %s", baseRef)
+			results = append(results, result)
+			continue
+		}
+
+		result.Ran = true
+		out, err := stage.run()
+		if err != nil {
+			result.Passed = false
+			out = err.Error()
+		}
+		result.Output = out
+		results = append(results, result)
+	}
+
+	if err := stagesFailed(results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// List the files changed in the project relative to a git ref,
+// including uncommitted and untracked files, with paths relative to the
+// project root
+func (g *Golang) changedFiles(ctx context.Context, ref string) ([]string, error) {
+	// Run from the project root so the diff and the untracked files share a
+	// base whatever the workdir, --relative in case the repository root is above
+	script := `cd "$2" && git -c safe.directory='*' diff --name-only --relative "$1" -- && git -c safe.directory='*' ls-files --others --exclude-standard`
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ctr.WithExec([]string{"sh", "-c", script, "sh", ref, PROJ_MOUNT}).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff against %s, is the .git directory included in the source? %w", ref, err)
+	}
+	return strings.Fields(out), nil
+}
+
+// List the files embedded with //go:embed by any package, relative to the
+// project root, only asking go list when a changed file looks like docs
+func (g *Golang) embeddedFiles(ctx context.Context, changed []string) (map[string]bool, error) {
+	embedded := map[string]bool{}
+	docs := false
+	for _, file := range changed {
+		docs = docs || classifyChange(file, embedded) == changeDocs
+	}
+	if !docs {
+		return embedded, nil
+	}
+
+	pkgs, err := g.List(ctx, nil, "./...")
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		for _, files := range [][]string{pkg.EmbedFiles, pkg.TestEmbedFiles, pkg.XTestEmbedFiles} {
+			for _, file := range files {
+				embedded[path.Join(g.Workdir, pkg.Dir, file)] = true
+			}
+		}
+	}
+	return embedded, nil
+}
+
+// Categorize a changed file path, given the files embedded by packages
+func classifyChange(file string, embedded map[string]bool) string {
+	base := path.Base(file)
+	switch {
+	case strings.HasPrefix(file, "vendor/"):
+		return changeVendor
+	case strings.HasPrefix(base, ".golangci."):
+		return changeLintConfig
+	case embedded[file], strings.Contains("/"+file, "/testdata/"):
+		// Read by the build or the tests, whatever the extension
+		return changeCode
+	case strings.HasPrefix(file, "docs/"),
+		strings.HasSuffix(base, ".md"),
+		strings.HasSuffix(base, ".txt"),
+		strings.HasPrefix(base, "LICENSE"):
+		return changeDocs
+	}
+	// Anything unrecognised could affect the build, so treat it as code
+	return changeCode
+}
+
+// Whether any of the changed kinds should trigger the stage
+func triggered(stage string, kinds map[string]bool) bool {
+	for _, kind := range stageTriggers[stage] {
+		if kinds[kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// Return an error summarising every stage if any of them failed
+func stagesFailed(results []*StageResult) error {
+	failed := false
+	var summary strings.Builder
+	for _, r := range results {
+		status := "passed"
+		switch {
+		case !r.Ran:
+			status = "skipped"
+		case !r.Passed:
+			status = "failed"
+			failed = true
+		}
+		fmt.Fprintf(&summary, "== %s: %s\n%s\n", r.Name, status, r.Output)
+	}
+	if !failed {
+		return nil
+	}
+	return fmt.Errorf("one or more stages failed\n%s", summary.String())
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestClassifyChange(t *testing.T) {
+	embedded := map[string]bool{
+		"web/templates/index.txt": true,
+		"README.md":               false,
+	}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"main.go", changeCode},
+		{"go.mod", changeCode},
+		{"Makefile", changeCode},
+		{"README.md", changeDocs},
+		{"docs/guide.md", changeDocs},
+		{"CHANGES.txt", changeDocs},
+		{"LICENSE", changeDocs},
+		{"vendor/github.com/foo/bar/bar.go", changeVendor},
+		{".golangci.yml", changeLintConfig},
+		{"pkg/foo/testdata/golden.txt", changeCode},
+		{"testdata/README.md", changeCode},
+		{"web/templates/index.txt", changeCode},
+		{"web/templates/unused.txt", changeDocs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := classifyChange(tt.file, embedded); got != tt.want {
+				t.Errorf("classifyChange(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
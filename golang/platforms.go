@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The platforms reported by each Go version, so they're only listed once per call
+var (
+	platforms   = map[string][]string{}
+	platformsMu sync.Mutex
+)
+
+// List the GOOS/GOARCH pairs supported by the Go toolchain, e.g. linux/amd64
+func (g *Golang) Platforms(ctx context.Context) ([]string, error) {
+	platformsMu.Lock()
+	defer platformsMu.Unlock()
+	if list, ok := platforms[g.GoVersion]; ok {
+		return list, nil
+	}
+
+	out, err := g.Ctr.WithExec([]string{"go", "tool", "dist", "list"}).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the supported platforms: %w", err)
+	}
+	list := strings.Fields(out)
+	platforms[g.GoVersion] = list
+	return list, nil
+}
+
+// Private func checking the toolchain can build for the platform
+func (g *Golang) checkPlatform(ctx context.Context, goos, goarch string) error {
+	list, err := g.Platforms(ctx)
+	if err != nil {
+		return err
+	}
+	if contains(list, goos+"/"+goarch) {
+		return nil
+	}
+
+	// Suggest the architectures for a known OS, otherwise the known OSes
+	arches := map[string]bool{}
+	oses := map[string]bool{}
+	for _, p := range list {
+		os, arch, _ := strings.Cut(p, "/")
+		oses[os] = true
+		if os == goos {
+			arches[arch] = true
+		}
+	}
+	if len(arches) > 0 {
+		return fmt.Errorf("unsupported GOARCH %q for GOOS %s, valid values: %s", goarch, goos, sortedKeys(arches))
+	}
+	return fmt.Errorf("unsupported GOOS %q, valid values: %s", goos, sortedKeys(oses))
+}
+
+// The keys of a set, sorted and comma separated
+func sortedKeys(set map[string]bool) string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
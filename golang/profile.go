@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Where the profiles are written in the container
+const PROFILE_DIR = "/out/profiles/"
+
+// Run a package's tests or benchmarks and collect pprof profiles
+//
+// The profiles are returned with the test binary, named test.bin, which
+// `go tool pprof` needs to symbolize them
+func (g *Golang) Profile(
+	ctx context.Context,
+	// The Go source code to profile
+	// +optional
+	source *Directory,
+	// The package to profile, e.g. ./internal/parser
+	component string,
+	// The benchmarks to run, e.g. BenchmarkParse, only tests run when empty
+	// +optional
+	bench string,
+	// The tests to run, defaulting to none when benchmarking
+	// +optional
+	run string,
+	// Collect a CPU profile
+	// +optional
+	// +default=true
+	cpu bool,
+	// Collect a memory profile
+	// +optional
+	// +default=true
+	mem bool,
+	// Collect a goroutine blocking profile
+	// +optional
+	block bool,
+	// Collect a mutex contention profile
+	// +optional
+	mutex bool,
+) (*Directory, error) {
+	if strings.Contains(component, "...") {
+		return nil, fmt.Errorf("profiles can only be collected for a single package, got %s", component)
+	}
+	if !cpu && !mem && !block && !mutex {
+		return nil, fmt.Errorf("no profiles selected")
+	}
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	ctr, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{"go", "test", "-count=1", "-o", PROFILE_DIR + "test.bin"}
+	if run == "" && bench != "" {
+		run = "^$"
+	}
+	if run != "" {
+		command = append(command, "-run", run)
+	}
+	if bench != "" {
+		command = append(command, "-bench", bench, "-benchmem")
+	}
+	for _, p := range []struct {
+		enabled bool
+		flag    string
+	}{
+		{cpu, "-cpuprofile=" + PROFILE_DIR + "cpu.pprof"},
+		{mem, "-memprofile=" + PROFILE_DIR + "mem.pprof"},
+		{block, "-blockprofile=" + PROFILE_DIR + "block.pprof"},
+		{mutex, "-mutexprofile=" + PROFILE_DIR + "mutex.pprof"},
+	} {
+		if p.enabled {
+			command = append(command, p.flag)
+		}
+	}
+
+	dir := ctr.
+		WithExec([]string{"mkdir", "-p", PROFILE_DIR}).
+		WithExec(g.deadlineCommand(append(command, component))).
+		Directory(PROFILE_DIR)
+	if _, err := g.span(ctx, "profile", func(ctx context.Context) (string, error) {
+		_, err := dir.Sync(ctx)
+		return "", err
+	}); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// The image produced by Publish
+type PublishResult struct {
+	// The image references, one for each tag
+	Refs []string
+	// The manifest digest of the image
+	Digest string
+	// Whether the image was pushed to the registry
+	Pushed bool
+	// The image container
+	Image *Container
+}
+
+// Build the Go project into an image and publish it to a registry
+//
+// With push disabled the image is built and assembled but not pushed, and the
+// digest it would be published with is reported instead
+func (g *Golang) Publish(
+	ctx context.Context,
+	// The Go source code to build
+	// +optional
+	source *Directory,
+	// Arguments to `go build`
+	// +optional
+	args []string,
+	// The image reference to publish to, e.g. registry.example.com/app:1.0
+	ref string,
+	// Base container in which to copy the build
+	// +optional
+	base *Container,
+	// Push the image, set to false to only validate it builds
+	// +optional
+	// +default=true
+	push bool,
+	// Additional tags to publish the same image under
+	// +optional
+	tags []string,
+	// The registry username
+	// +optional
+	username string,
+	// The registry password or token
+	// +optional
+	password *Secret,
+) (*PublishResult, error) {
+	image, err := g.BuildContainer(ctx, source, args, "", "", base)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, tag := splitRef(ref)
+	refs := []string{ref}
+	for _, t := range tags {
+		if t != tag {
+			refs = append(refs, repo+":"+t)
+		}
+	}
+
+	result := &PublishResult{Refs: refs, Image: image}
+	if !push {
+		result.Digest, err = g.imageDigest(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	registry := registryHost(repo)
+	if password != nil {
+		image = image.WithRegistryAuth(registry, username, password)
+	}
+	for _, r := range refs {
+		published, err := image.Publish(ctx, r)
+		if err != nil {
+			return nil, publishError(r, registry, err)
+		}
+		_, result.Digest, _ = strings.Cut(published, "@")
+	}
+	result.Pushed = true
+	return result, nil
+}
+
+// Build the Go project into an image and export it as an OCI tarball instead of pushing it
+//
+// The tarball can be moved into air-gapped environments and loaded with
+// `docker load` or pushed from there with a tool like skopeo or crane
+func (g *Golang) PublishToTarball(
+	ctx context.Context,
+	// The Go source code to build
+	// +optional
+	source *Directory,
+	// Arguments to `go build`
+	// +optional
+	args []string,
+	// The image reference recorded in the image, e.g. registry.example.com/app:1.0
+	ref string,
+	// Base container in which to copy the build
+	// +optional
+	base *Container,
+) (*File, error) {
+	image, err := g.BuildContainer(ctx, source, args, "", "", base)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, tag := splitRef(ref)
+	name := fmt.Sprintf("%s_%s.tar", path.Base(repo), tag)
+	tarball := image.
+		WithLabel("org.opencontainers.image.ref.name", ref).
+		AsTarball()
+	return dag.Directory().WithFile(name, tarball).File(name), nil
+}
+
+// Split an image reference into its repository and tag
+func splitRef(ref string) (string, string) {
+	ref, _, _ = strings.Cut(ref, "@")
+	slash := strings.LastIndex(ref, "/")
+	colon := strings.LastIndex(ref, ":")
+	// A colon before the last slash is a registry port, not a tag
+	if colon > slash {
+		return ref[:colon], ref[colon+1:]
+	}
+	return ref, "latest"
+}
+
+// The registry host of an image repository, defaulting to Docker Hub
+func registryHost(repo string) string {
+	host, _, found := strings.Cut(repo, "/")
+	if found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return host
+	}
+	return "docker.io"
+}
+
+// Explain a failed push, keeping the registry's response
+func publishError(ref, registry string, err error) error {
+	msg := strings.ToLower(err.Error())
+	for _, auth := range []string{"401", "403", "unauthorized", "denied", "authentication required"} {
+		if strings.Contains(msg, auth) {
+			return fmt.Errorf("registry %s rejected the credentials pushing %s, check the username and password: %w", registry, ref, err)
+		}
+	}
+	return fmt.Errorf("unable to push %s to %s: %w", ref, registry, err)
+}
+
+// The manifest digest of an image, read from its OCI layout without pushing it
+func (g *Golang) imageDigest(ctx context.Context, image *Container) (string, error) {
+	index, err := g.from("alpine:3.18").
+		WithMountedFile("/image.tar", image.AsTarball()).
+		WithExec([]string{"tar", "-xOf", "/image.tar", "index.json"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the image index: %w", err)
+	}
+
+	var layout struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal([]byte(index), &layout); err != nil {
+		return "", fmt.Errorf("unable to parse the image index: %w", err)
+	}
+	if len(layout.Manifests) == 0 {
+		return "", fmt.Errorf("image index has no manifests")
+	}
+	return layout.Manifests[0].Digest, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Hostname the registry is bound under, in the project and dockerd containers
+const REGISTRY_HOST = "registry"
+
+// Start a registry:2 container serving plain HTTP on the given port
+func (g *Golang) RegistryService(
+	// +optional
+	// +default=5000
+	port int,
+) *Service {
+	return g.from("registry:2").
+		WithEnvVariable("REGISTRY_HTTP_ADDR", fmt.Sprintf("0.0.0.0:%d", port)).
+		WithExposedPort(port).
+		AsService()
+}
+
+// Bind a local registry alongside dockerd so tests can push and pull images
+//
+// The registry is reachable as registry:<port> from both the project and the
+// dockerd containers, and dockerd trusts it over plain HTTP. Services don't
+// share a network namespace, so localhost:<port> points at the container
+// itself rather than the registry. The address is exported as REGISTRY_ADDR
+func (g *Golang) WithRegistry(
+	// +optional
+	// +default=5000
+	port int,
+) (*Golang, error) {
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid registry port %d", port)
+	}
+	g.RegistryPort = port
+	return g, nil
+}
+
+// Private func returning the registry address, empty without WithRegistry
+func (g *Golang) registryAddr() string {
+	if g.RegistryPort == 0 {
+		return ""
+	}
+	return REGISTRY_HOST + ":" + strconv.Itoa(g.RegistryPort)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Cross-build the project and package each platform's binaries into a named archive
+//
+// The returned Directory holds one archive per platform and a SHA256SUMS file,
+// the layout GitHub Releases expects. Archives are named by the template, with
+// {name}, {version}, {os} and {arch} replaced, plus the format's extension
+func (g *Golang) Release(
+	ctx context.Context,
+	// The Go source code to build
+	// +optional
+	source *Directory,
+	// Arguments to `go build`
+	// +optional
+	args []string,
+	// The platforms to build for, as os/arch, e.g. linux/amd64
+	platforms []string,
+	// The version being released, e.g. v1.2.3
+	version string,
+	// The project name, defaulting to the last element of the module path
+	// +optional
+	name string,
+	// The archive name template
+	// +optional
+	// +default="{name}_{version}_{os}_{arch}"
+	nameTemplate string,
+	// The archive format, one of tar.gz, tar or zip
+	// +optional
+	// +default="tar.gz"
+	format string,
+) (*Directory, error) {
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platforms to release for")
+	}
+	if source != nil {
+		g = g.WithProject(source)
+	}
+	if nameTemplate == "" {
+		nameTemplate = "{name}_{version}_{os}_{arch}"
+	}
+	if format == "" {
+		format = "tar.gz"
+	}
+	if name == "" {
+		module, err := g.ModulePath(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		name = path.Base(module)
+	}
+
+	releases := dag.Directory()
+	seen := map[string]string{}
+	for _, platform := range platforms {
+		os, arch, ok := strings.Cut(platform, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid platform %q, expected os/arch", platform)
+		}
+		archive := strings.NewReplacer(
+			"{name}", name,
+			"{version}", version,
+			"{os}", os,
+			"{arch}", arch,
+		).Replace(nameTemplate)
+		if other, ok := seen[archive]; ok {
+			return nil, fmt.Errorf("platforms %s and %s would both be released as %s, add {os} and {arch} to the template", other, platform, archive)
+		}
+		seen[archive] = platform
+
+		bin, err := g.Build(ctx, nil, args, arch, os, false, true, false, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		file := dag.Utils().Archive(bin, UtilsArchiveOpts{
+			Format:       format,
+			Level:        9,
+			Reproducible: true,
+			Root:         archive,
+		})
+		releases = releases.WithFile(archive+"."+format, file)
+	}
+
+	sums := g.from("alpine:3.18").
+		WithMountedDirectory("/release", releases).
+		WithWorkdir("/release").
+		WithExec([]string{"sh", "-c", "sha256sum * > /tmp/SHA256SUMS"}).
+		File("/tmp/SHA256SUMS")
+	return releases.WithFile("SHA256SUMS", sums), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Private func running the tests, re-running failed tests up to retries more times
+//
+// Only the failed top-level tests are re-run, selected with -run. Tests that
+// only passed on a retry are reported as flaky so they aren't hidden
+func (g *Golang) testWithRetries(ctx context.Context, packages []string, coverageLocation string, race bool, extraArgs []string, retries int) (string, error) {
+	attempt := func(n int, pkgs []string, args []string) (string, map[string][]string, []string, error) {
+		a := *g
+		// Vary the exec so the engine doesn't serve a cached result
+		a.Env = append(append([]*EnvVar{}, g.Env...), &EnvVar{Name: "TEST_ATTEMPT", Value: strconv.Itoa(n)})
+		ctr, err := a.testBase(ctx, race)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		// Read the events back from a file, exec output is truncated when large
+		command := testCommand(pkgs, coverageLocation, race, append(append([]string{}, args...), "-json"))
+		script := fmt.Sprintf(`"$@" >%s 2>%s; echo $? >%s`, TEST_JSON, TEST_STDERR, TEST_EXIT)
+		ctr = ctr.WithExec(append([]string{"sh", "-c", script, "sh"}, g.deadlineCommand(command)...))
+		events, err := ctr.File(TEST_JSON).Contents(ctx)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		exit, err := ctr.File(TEST_EXIT).Contents(ctx)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		out, failed, broken, err := parseAttempt(events)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if code := strings.TrimSpace(exit); code != "0" && len(failed) == 0 && len(broken) == 0 {
+			// Killed by the deadline or go test couldn't start, so no failure was reported
+			stderr, _ := ctr.File(TEST_STDERR).Contents(ctx)
+			err := fmt.Errorf("%w: go test exited with code %s\n%s%s", ErrTestFailed, code, out, stderr)
+			if g.Deadline != "" && code == strconv.Itoa(TIMEOUT_EXIT_CODE) {
+				err = fmt.Errorf("deadline of %s exceeded: %w", g.Deadline, err)
+			}
+			return "", nil, nil, err
+		}
+		return out, failed, broken, nil
+	}
+
+	out, failed, broken, err := attempt(1, packages, extraArgs)
+	if err != nil {
+		return "", err
+	}
+	var summary strings.Builder
+	for n := 2; n <= retries+1 && len(failed) > 0 && len(broken) == 0; n++ {
+		next := map[string][]string{}
+		for _, pkg := range sortedPackages(failed) {
+			tests := failed[pkg]
+			args := append(append([]string{}, extraArgs...), "-count=1", "-run", testsPattern(tests))
+			retryOut, retryFailed, retryBroken, err := attempt(n, []string{pkg}, args)
+			if err != nil {
+				return "", err
+			}
+			out += retryOut
+			broken = append(broken, retryBroken...)
+			still := map[string]bool{}
+			for _, t := range retryFailed[pkg] {
+				still[t] = true
+			}
+			for _, t := range tests {
+				if still[t] {
+					next[pkg] = append(next[pkg], t)
+				} else {
+					fmt.Fprintf(&summary, "flaky: %s %s passed on attempt %d\n", pkg, t, n)
+				}
+			}
+		}
+		failed = next
+	}
+
+	for _, pkg := range sortedPackages(failed) {
+		for _, t := range failed[pkg] {
+			fmt.Fprintf(&summary, "failed: %s %s after %d attempts\n", pkg, t, retries+1)
+		}
+	}
+	for _, pkg := range broken {
+		fmt.Fprintf(&summary, "failed: %s didn't build or run\n", pkg)
+	}
+	if len(failed) > 0 || len(broken) > 0 {
+		return "", fmt.Errorf("%w\n%s\n%s", ErrTestFailed, summary.String(), out)
+	}
+	return out + summary.String(), nil
+}
+
+// Parse go test -json events into the test output, the failed top-level tests
+// of each package and the packages that failed without a failing test
+func parseAttempt(events string) (string, map[string][]string, []string, error) {
+	var out strings.Builder
+	failed := map[string][]string{}
+	failedPkgs := map[string]bool{}
+	for _, line := range strings.Split(events, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			if line != "" {
+				fmt.Fprintln(&out, line)
+			}
+			continue
+		}
+		var e testEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return "", nil, nil, fmt.Errorf("unable to parse go test event %q: %w", line, err)
+		}
+		switch {
+		case e.Action == "output":
+			out.WriteString(e.Output)
+		case e.Action == "fail" && e.Test == "":
+			failedPkgs[e.Package] = true
+		case e.Action == "fail" && !strings.Contains(e.Test, "/"):
+			// Subtests are re-run through their parent
+			failed[e.Package] = append(failed[e.Package], e.Test)
+		}
+	}
+
+	var broken []string
+	for pkg := range failedPkgs {
+		if len(failed[pkg]) == 0 {
+			broken = append(broken, pkg)
+		}
+	}
+	sort.Strings(broken)
+	return out.String(), failed, broken, nil
+}
+
+// A -run pattern matching exactly the given top-level tests
+func testsPattern(tests []string) string {
+	quoted := make([]string, len(tests))
+	for i, t := range tests {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
+// The packages with failed tests, in a stable order
+func sortedPackages(failed map[string][]string) []string {
+	pkgs := make([]string, 0, len(failed))
+	for pkg := range failed {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
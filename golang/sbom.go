@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// The syft image used to generate SBOMs
+const SYFT_IMAGE = "anchore/syft:v1.4.1"
+
+// The syft output format and file name for each SBOM format
+var sbomFormats = map[string][2]string{
+	"cyclonedx": {"cyclonedx-json", "sbom.cdx.json"},
+	"spdx":      {"spdx-json", "sbom.spdx.json"},
+}
+
+// Generate an SBOM of the Go module or of a built binary with syft
+//
+// Without a binary the dependencies are read from the project's go.mod and
+// go.sum. A binary's are read from the build info embedded by the Go toolchain,
+// so only what was linked in is listed
+func (g *Golang) GenerateSBOM(
+	ctx context.Context,
+	// The Go source code to describe
+	// +optional
+	source *Directory,
+	// A binary to describe instead of the module, e.g. from Build
+	// +optional
+	binary *File,
+	// The SBOM format, cyclonedx or spdx
+	// +optional
+	// +default="cyclonedx"
+	format string,
+) (*File, error) {
+	if format == "" {
+		format = "cyclonedx"
+	}
+	syftFormat, ok := sbomFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SBOM format %q, valid values: cyclonedx, spdx", format)
+	}
+
+	ctr := g.from(SYFT_IMAGE)
+	var target string
+	if binary != nil {
+		ctr = ctr.WithMountedFile("/tmp/binary", binary)
+		target = "file:/tmp/binary"
+	} else {
+		if source != nil {
+			g = g.WithProject(source)
+		}
+		src, err := g.projectSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ctr = ctr.WithMountedDirectory(PROJ_MOUNT, src)
+		target = "dir:" + g.workdir()
+	}
+
+	out := "/tmp/" + syftFormat[1]
+	return ctr.
+		WithExec([]string{"/syft", "scan", target, "-o", syftFormat[0] + "=" + out}, ContainerWithExecOpts{
+			SkipEntrypoint: true,
+		}).
+		File(out), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// How long to wait for bound services to become ready by default
+const DEFAULT_READY_TIMEOUT = 60
+
+// Retries a probe with backoff until it succeeds or the timeout passes, then
+// runs the command it wraps
+const WAIT_READY = `name="$1"; timeout="$2"; probe="$3"; shift 3
+start=$(date +%s); delay=1
+until sh -c "$probe" >/dev/null 2>&1; do
+  if [ $(( $(date +%s) - start )) -ge "$timeout" ]; then
+    echo "$name didn't become ready within ${timeout}s, probe: $probe" >&2
+    exit 1
+  fi
+  sleep "$delay"
+  [ "$delay" -lt 5 ] && delay=$((delay * 2))
+done
+exec "$@"`
+
+// A service bound to the prepared container, with an optional readiness probe
+type ServiceBinding struct {
+	Alias   string
+	Service *Service
+	Probe   string
+}
+
+// Bind a service, such as a database, to the container used for build/test/lint
+//
+// Dagger waits for the service's exposed ports to accept connections, but
+// that doesn't mean it's ready to serve. With a probe, a shell command like
+// `pg_isready -h db`, go commands only run once the probe succeeds
+func (g *Golang) WithService(
+	// The hostname the service is reachable under
+	alias string,
+	service *Service,
+	// A shell command that succeeds once the service is ready
+	// +optional
+	probe string,
+) *Golang {
+	g.Services = append(g.Services, &ServiceBinding{Alias: alias, Service: service, Probe: probe})
+	return g
+}
+
+// Set how long to wait for bound services, including dockerd, to become ready
+func (g *Golang) WithReadyTimeout(
+	// The timeout in seconds
+	seconds int,
+) (*Golang, error) {
+	if seconds < 1 {
+		return nil, fmt.Errorf("ready timeout must be at least 1 second, got %d", seconds)
+	}
+	g.ReadyTimeout = seconds
+	return g, nil
+}
+
+// Private func binding the services added with WithService and waiting for them
+func (g *Golang) bindServices(ctx context.Context, c *Container) (*Container, error) {
+	for _, svc := range g.Services {
+		c = c.WithServiceBinding(svc.Alias, svc.Service)
+	}
+	for _, svc := range g.Services {
+		if svc.Probe == "" {
+			continue
+		}
+		var err error
+		c, err = g.waitReady(ctx, c, svc.Alias, svc.Probe)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Private func running a probe before each command, until it succeeds or the
+// ready timeout passes
+//
+// The probe wraps the entrypoint rather than running as its own exec, which
+// Dagger would cache and so skip, while the service it checked is started
+// afresh for every command that uses it
+func (g *Golang) waitReady(ctx context.Context, c *Container, name, probe string) (*Container, error) {
+	timeout := g.ReadyTimeout
+	if timeout == 0 {
+		timeout = DEFAULT_READY_TIMEOUT
+	}
+	entrypoint, err := c.Entrypoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := []string{"sh", "-c", WAIT_READY, "sh", name, fmt.Sprint(timeout), probe}
+	return c.WithEntrypoint(append(wrapper, entrypoint...)), nil
+}
+
+// The probe for dockerd, its API answers /_ping once it accepts requests
+func dockerProbe(dockerHost string) string {
+	return fmt.Sprintf("wget -q -O- http://%s/_ping", strings.TrimPrefix(dockerHost, "tcp://"))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// The cosign image the binary is copied from
+const COSIGN_IMAGE = "gcr.io/projectsigstore/cosign:v2.2.4"
+
+// Where Sign writes the signature and certificate
+const SIGN_DIR = "/tmp/cosign"
+
+// The signature produced by Sign
+type SignResult struct {
+	// The base64 encoded signature
+	Signature *File
+	// The Fulcio signing certificate, only set for keyless signing
+	Certificate *File
+	// The signed image reference by digest, only set when signing an image
+	Ref string
+}
+
+// Sign a build artifact or an image with cosign
+//
+// Key-based signing uses a cosign private key, e.g. from `cosign generate-key-pair`.
+// Keyless signing gets a short-lived certificate from Fulcio for the OIDC
+// identity token and records the signature in the Rekor transparency log.
+// Images are published to ref first, and the signature is pushed next to them
+func (g *Golang) Sign(
+	ctx context.Context,
+	// The artifact to sign, e.g. from Build or Release
+	// +optional
+	file *File,
+	// The image to publish and sign, e.g. from BuildContainer
+	// +optional
+	image *Container,
+	// The reference to publish the image to, e.g. registry.example.com/app:1.0
+	// +optional
+	ref string,
+	// The cosign private key, for key-based signing
+	// +optional
+	key *Secret,
+	// The password of the private key
+	// +optional
+	password *Secret,
+	// Sign keyless with an OIDC identity instead of a key
+	// +optional
+	keyless bool,
+	// The OIDC identity token for keyless signing, e.g. from the CI provider
+	// +optional
+	identityToken *Secret,
+	// The registry username
+	// +optional
+	username string,
+	// The registry password or token
+	// +optional
+	registryPassword *Secret,
+) (*SignResult, error) {
+	if (file == nil) == (image == nil) {
+		return nil, fmt.Errorf("pass either a file or an image to sign")
+	}
+	if image != nil && ref == "" {
+		return nil, fmt.Errorf("a ref is needed to publish the image before signing it")
+	}
+
+	ctr := g.from("alpine:3.18").
+		WithFile("/usr/local/bin/cosign", g.from(COSIGN_IMAGE).File("/ko-app/cosign")).
+		WithExec([]string{"mkdir", "-p", SIGN_DIR})
+
+	var flags []string
+	if keyless {
+		if key != nil {
+			return nil, fmt.Errorf("keyless signing doesn't use a key, pass one or the other")
+		}
+		if identityToken == nil {
+			return nil, fmt.Errorf("keyless signing needs an OIDC identity token")
+		}
+		ctr = ctr.WithSecretVariable("SIGSTORE_ID_TOKEN", identityToken)
+		flags = []string{"--output-certificate", SIGN_DIR + "/cert.pem"}
+	} else {
+		if key == nil {
+			return nil, fmt.Errorf("a key is needed for key-based signing, or set keyless")
+		}
+		if err := checkSigningKey(ctx, key); err != nil {
+			return nil, err
+		}
+		ctr = ctr.WithSecretVariable("COSIGN_KEY", key)
+		if password != nil {
+			ctr = ctr.WithSecretVariable("COSIGN_PASSWORD", password)
+		} else {
+			ctr = ctr.WithEnvVariable("COSIGN_PASSWORD", "")
+		}
+		flags = []string{"--key", "env://COSIGN_KEY"}
+	}
+	flags = append(flags, "--yes", "--output-signature", SIGN_DIR+"/sig")
+
+	result := &SignResult{}
+	var command []string
+	if file != nil {
+		command = append(append([]string{"cosign", "sign-blob"}, flags...), "/tmp/artifact")
+		ctr = ctr.WithMountedFile("/tmp/artifact", file)
+	} else {
+		repo, _ := splitRef(ref)
+		registry := registryHost(repo)
+		if registryPassword != nil {
+			image = image.WithRegistryAuth(registry, username, registryPassword)
+		}
+		published, err := image.Publish(ctx, ref)
+		if err != nil {
+			return nil, publishError(ref, registry, err)
+		}
+		_, digest, _ := strings.Cut(published, "@")
+		result.Ref = repo + "@" + digest
+
+		command = append(append([]string{"cosign", "sign"}, flags...), result.Ref)
+		if registryPassword != nil {
+			// Expand the password from the secret in the container rather than passing it as an argument
+			ctr = ctr.
+				WithEnvVariable("REGISTRY_USERNAME", username).
+				WithSecretVariable("REGISTRY_PASSWORD", registryPassword)
+			command = append([]string{"sh", "-c", `exec "$@" --registry-username "$REGISTRY_USERNAME" --registry-password "$REGISTRY_PASSWORD"`, "sh"}, command...)
+		}
+	}
+
+	signed, err := ctr.WithExec(command).Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign: %w", err)
+	}
+	result.Signature = signed.File(SIGN_DIR + "/sig")
+	if keyless {
+		result.Certificate = signed.File(SIGN_DIR + "/cert.pem")
+	}
+	return result, nil
+}
+
+// Private func checking the signing key is a PEM encoded private key
+//
+// cosign only reports it can't decrypt a malformed key, which reads like a
+// wrong password
+func checkSigningKey(ctx context.Context, key *Secret) error {
+	plaintext, err := key.Plaintext(ctx)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode([]byte(strings.TrimSpace(plaintext)))
+	if block == nil {
+		return fmt.Errorf("malformed signing key: not PEM encoded, expected a key from `cosign generate-key-pair`")
+	}
+	if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+		return fmt.Errorf("malformed signing key: got a %s, expected a private key", block.Type)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// Where the SSH private key is mounted in the container
+const SSH_KEY_PATH = "/root/.ssh/id_dagger"
+
+// How git runs ssh, with the mounted key
+const GIT_SSH_COMMAND = "ssh -i " + SSH_KEY_PATH + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new"
+
+// Fetch private modules from a git host over SSH using a private key
+//
+// https:// URLs for the host are rewritten to SSH, so go commands clone
+// git@host:org/repo. The host key is accepted on first use as the container
+// has no known_hosts. Set GOPRIVATE, e.g. with WithEnv, so the modules skip the
+// public proxy and checksum database
+func (g *Golang) WithSSHKey(
+	// The SSH private key
+	key *Secret,
+	// The git host the key is for
+	// +optional
+	// +default="github.com"
+	host string,
+) *Golang {
+	if host == "" {
+		host = "github.com"
+	}
+	g.SSHKey = key
+	g.SSHHost = host
+	return g
+}
+
+// Private func mounting the SSH key and pointing git at it
+func (g *Golang) withSSH(c *Container) *Container {
+	if g.SSHKey == nil {
+		return c
+	}
+	return c.
+		WithMountedSecret(SSH_KEY_PATH, g.SSHKey, ContainerWithMountedSecretOpts{
+			// ssh refuses keys readable by others
+			Owner: "root",
+			Mode:  0600,
+		}).
+		WithEnvVariable("GIT_SSH_COMMAND", GIT_SSH_COMMAND).
+		WithExec(g.sshConfigCommand())
+}
+
+// Private func returning the command rewriting https:// URLs of the host to SSH
+func (g *Golang) sshConfigCommand() []string {
+	return []string{"git", "config", "--global", fmt.Sprintf("url.git@%s:.insteadOf", g.SSHHost), fmt.Sprintf("https://%s/", g.SSHHost)}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Where tools installed with WithGoTool are placed, already on the PATH of
+// the golang images
+//
+// The binaries are part of the container's filesystem rather than a cache
+// volume, so a cached install can't point at a binary pruned from the volume.
+// The module and build caches still speed up installs
+const TOOLS_BIN = "/usr/local/bin"
+
+// Install a Go tool into the container's PATH, e.g. go.uber.org/mock/mockgen@v0.4.0
+//
+// Tools are installed when the container is prepared, so they're available to
+// Test and every other method running in the project. Without a version the
+// latest release is installed. Adding a tool again, e.g. at another version,
+// replaces it, as both would be installed as the same binary
+func (g *Golang) WithGoTool(
+	// The tool's package path, optionally with an @version suffix
+	pkg string,
+) *Golang {
+	if !strings.Contains(pkg, "@") {
+		pkg += "@latest"
+	}
+	name, _, _ := strings.Cut(pkg, "@")
+	var tools []string
+	for _, tool := range g.Tools {
+		if other, _, _ := strings.Cut(tool, "@"); other != name {
+			tools = append(tools, tool)
+		}
+	}
+	g.Tools = append(tools, pkg)
+	return g
+}
+
+// Private func installing the tools added with WithGoTool
+func (g *Golang) installTools(ctx context.Context, c *Container) (*Container, error) {
+	if len(g.Tools) == 0 {
+		return c, nil
+	}
+
+	c = c.WithEnvVariable("GOBIN", TOOLS_BIN)
+	for _, tool := range g.Tools {
+		c = c.WithExec(installCommand(tool))
+		if _, err := c.Sync(ctx); err != nil {
+			return nil, fmt.Errorf("unable to install tool %s, check the module path and version: %w", tool, commandError(ErrToolInstall, err))
+		}
+	}
+	return c, nil
+}
+
+// Private func returning the command installing a tool added with WithGoTool
+func installCommand(tool string) []string {
+	// GOFLAGS like -mod=vendor apply to the project, not to pkg@version installs
+	return []string{"env", "-u", "GOFLAGS", "go", "install", tool}
+}
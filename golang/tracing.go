@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait for the collector to accept a span
+const TRACE_EXPORT_TIMEOUT = 5 * time.Second
+
+var (
+	traceID     string
+	traceIDOnce sync.Once
+)
+
+// Emit an OpenTelemetry span for each build, test and lint operation
+//
+// Spans are sent as OTLP/HTTP JSON, so the endpoint must be reachable from the
+// Dagger engine. All spans from a single call share one trace. They carry no
+// cache hit attribute: the SDK doesn't report whether an exec was served from
+// the engine's cache, only its result, so a span's duration is the best hint
+func (g *Golang) WithTracing(
+	// The OTLP/HTTP collector endpoint, e.g. http://otel-collector:4318
+	endpoint string,
+	// The service.name resource attribute reported with each span
+	// +optional
+	// +default="dagger-golang"
+	serviceName string,
+) *Golang {
+	g.TraceEndpoint = strings.TrimSuffix(endpoint, "/")
+	g.TraceService = serviceName
+	return g
+}
+
+// Run fn bounded by the deadline, inside a span named after the operation
+// when tracing is enabled
+func (g *Golang) span(ctx context.Context, name string, fn func(context.Context) (string, error)) (string, error) {
+	opCtx, cancel := g.withDeadline(ctx)
+	defer cancel()
+	if g.TraceEndpoint == "" {
+		out, err := fn(opCtx)
+		return out, g.deadlineError(err)
+	}
+
+	start := time.Now()
+	out, err := fn(opCtx)
+	err = g.deadlineError(err)
+	if exportErr := g.exportSpan(ctx, name, start, time.Now(), err); exportErr != nil {
+		log.Printf("unable to export span %s: %s", name, exportErr)
+	}
+	return out, err
+}
+
+func (g *Golang) exportSpan(ctx context.Context, name string, start, end time.Time, spanErr error) error {
+	attrs := []otlpAttribute{
+		stringAttr("golang.operation", name),
+	}
+	status := otlpStatus{Code: 1}
+	if spanErr != nil {
+		status = otlpStatus{Code: 2, Message: spanErr.Error()}
+		var execErr *ExecError
+		if errors.As(spanErr, &execErr) {
+			attrs = append(attrs,
+				intAttr("exec.exit_code", execErr.ExitCode),
+				stringAttr("exec.command", strings.Join(execErr.Cmd, " ")),
+			)
+		}
+	} else {
+		attrs = append(attrs, intAttr("exec.exit_code", 0))
+	}
+
+	payload := otlpPayload{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{stringAttr("service.name", g.TraceService)},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "golang"},
+				Spans: []otlpSpan{{
+					TraceID:           currentTraceID(),
+					SpanID:            randomHex(8),
+					Name:              name,
+					Kind:              1,
+					StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+					EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+					Attributes:        attrs,
+					Status:            status,
+				}},
+			}},
+		}},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, TRACE_EXPORT_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.TraceEndpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func currentTraceID() string {
+	traceIDOnce.Do(func() {
+		traceID = randomHex(16)
+	})
+	return traceID
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Minimal OTLP/HTTP JSON trace payload
+type otlpPayload struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int) otlpAttribute {
+	v := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &v}}
+}
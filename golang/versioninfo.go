@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// Stamp version information into the binaries built by Build and BuildVariants
+//
+// Each set value is injected with -ldflags -X into a string variable of the
+// package, version, commit and date unless named otherwise, alongside any
+// -ldflags passed to Build
+func (g *Golang) WithVersionInfo(
+	// The import path of the package declaring the variables, e.g. example.com/app/internal/build
+	// +optional
+	// +default="main"
+	pkg string,
+	// The value for the version variable, e.g. v1.2.3
+	// +optional
+	version string,
+	// The value for the commit variable
+	// +optional
+	commit string,
+	// The value for the date variable, e.g. 2024-05-01T12:00:00Z
+	// +optional
+	date string,
+	// The name of the version variable
+	// +optional
+	// +default="version"
+	versionVar string,
+	// The name of the commit variable
+	// +optional
+	// +default="commit"
+	commitVar string,
+	// The name of the date variable
+	// +optional
+	// +default="date"
+	dateVar string,
+) (*Golang, error) {
+	if pkg == "" {
+		pkg = "main"
+	}
+	for _, v := range []string{pkg, version, commit, date} {
+		if strings.ContainsAny(v, " \t\n'\"") {
+			return nil, fmt.Errorf("version info %q can't contain spaces or quotes", v)
+		}
+	}
+	vars := []*string{&versionVar, &commitVar, &dateVar}
+	for i, name := range []string{"version", "commit", "date"} {
+		if *vars[i] == "" {
+			*vars[i] = name
+		}
+		if !token.IsIdentifier(*vars[i]) {
+			return nil, fmt.Errorf("%q isn't a valid Go variable name", *vars[i])
+		}
+	}
+	g.VersionPkg = pkg
+	g.AppVersion = version
+	g.Commit = commit
+	g.Date = date
+	g.VersionVar = versionVar
+	g.CommitVar = commitVar
+	g.DateVar = dateVar
+	return g, nil
+}
+
+// Private func returning the -X linker flags for the version info
+func (g *Golang) versionLdflags() []string {
+	var flags []string
+	for _, v := range []struct{ name, value string }{
+		{g.VersionVar, g.AppVersion},
+		{g.CommitVar, g.Commit},
+		{g.DateVar, g.Date},
+	} {
+		if v.value != "" {
+			// One field per flag, so merging with the user's -ldflags keeps each -X
+			flags = append(flags, fmt.Sprintf("-X=%s.%s=%s", g.VersionPkg, v.name, v.value))
+		}
+	}
+	return flags
+}
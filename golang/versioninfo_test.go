@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersionLdflags(t *testing.T) {
+	g, err := (&Golang{}).WithVersionInfo("", "v1.2.3", "", "2024-05-01T12:00:00Z", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-X=main.version=v1.2.3", "-X=main.date=2024-05-01T12:00:00Z"}
+	if got := g.versionLdflags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("versionLdflags() = %q, want %q", got, want)
+	}
+
+	g, err = (&Golang{}).WithVersionInfo("example.com/app/internal/build", "v1.2.3", "abc123", "", "Version", "GitCommit", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"-X=example.com/app/internal/build.Version=v1.2.3", "-X=example.com/app/internal/build.GitCommit=abc123"}
+	if got := g.versionLdflags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("versionLdflags() = %q, want %q", got, want)
+	}
+
+	if _, err := (&Golang{}).WithVersionInfo("", "v1", "", "", "build-version", "", ""); err == nil {
+		t.Error("expected an error for a variable name that isn't an identifier")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"path"
+)
+
+// Private func finding the go.work governing the workdir, relative to the
+// project root, or empty outside a workspace
+//
+// Like the go command it looks in the workdir and then each parent directory.
+// Setting GOWORK with WithEnv, e.g. to off, disables the lookup
+func (g *Golang) workspaceFile(ctx context.Context) (string, error) {
+	for _, env := range g.Env {
+		if env.Name == "GOWORK" {
+			return "", nil
+		}
+	}
+	src, err := g.projectSource(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	dir := g.Workdir
+	if dir == "" {
+		dir = "."
+	}
+	for {
+		work := path.Join(dir, "go.work")
+		if _, err := src.File(work).Sync(ctx); err == nil {
+			return work, nil
+		}
+		if dir == "." {
+			return "", nil
+		}
+		dir = path.Dir(dir)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const (
+	ZIG_VERSION = "0.11.0"
+	ZIG_MOUNT   = "/opt/zig"
+)
+
+// Zig architecture names for each GOARCH
+var zigArch = map[string]string{
+	"386":     "x86",
+	"amd64":   "x86_64",
+	"arm":     "arm",
+	"arm64":   "aarch64",
+	"ppc64le": "powerpc64le",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// Zig OS and ABI names for each GOOS
+var zigOS = map[string]string{
+	"linux":   "linux-gnu",
+	"darwin":  "macos",
+	"windows": "windows-gnu",
+}
+
+// The zig target triple for a GOOS/GOARCH pair
+func zigTarget(goos, goarch string) (string, error) {
+	arch, ok := zigArch[goarch]
+	if !ok {
+		return "", fmt.Errorf("zig cross-compilation doesn't support GOARCH=%s", goarch)
+	}
+	os, ok := zigOS[goos]
+	if !ok {
+		return "", fmt.Errorf("zig cross-compilation doesn't support GOOS=%s", goos)
+	}
+	if goos == "linux" && goarch == "arm" {
+		os = "linux-gnueabihf"
+	}
+	return fmt.Sprintf("%s-%s", arch, os), nil
+}
+
+// Configure the container to cross-compile cgo with zig for the target platform
+func (g *Golang) withZig(ctr *Container, goos, goarch string) (*Container, error) {
+	env, err := zigEnv(goos, goarch)
+	if err != nil {
+		return nil, err
+	}
+
+	// zig runs on the engine's platform, not the target's
+	host, ok := zigArch[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("zig is not available for %s hosts", runtime.GOARCH)
+	}
+	name := fmt.Sprintf("zig-linux-%s-%s", host, ZIG_VERSION)
+	archive := dag.HTTP(fmt.Sprintf("https://ziglang.org/download/%s/%s.tar.xz", ZIG_VERSION, name))
+	zig := g.from("alpine:3.18").
+		WithExec([]string{"apk", "add", "--no-cache", "xz"}).
+		WithMountedFile("/tmp/zig.tar.xz", archive).
+		WithExec([]string{"tar", "-xJf", "/tmp/zig.tar.xz", "-C", "/opt"}).
+		Directory("/opt/" + name)
+
+	ctr = ctr.
+		WithMountedDirectory(ZIG_MOUNT, zig).
+		WithMountedCache("/root/.cache/zig", dag.CacheVolume("zigcache"))
+	for _, e := range env {
+		ctr = ctr.WithEnvVariable(e.Name, e.Value)
+	}
+	return ctr, nil
+}
+
+// Private func returning the env vars pointing cgo at zig for the target platform
+func zigEnv(goos, goarch string) ([]*EnvVar, error) {
+	target, err := zigTarget(goos, goarch)
+	if err != nil {
+		return nil, err
+	}
+	return []*EnvVar{
+		{Name: "CGO_ENABLED", Value: "1"},
+		{Name: "CC", Value: fmt.Sprintf("%s/zig cc -target %s", ZIG_MOUNT, target)},
+		{Name: "CXX", Value: fmt.Sprintf("%s/zig c++ -target %s", ZIG_MOUNT, target)},
+	}, nil
+}
@@ -3,33 +3,290 @@ package main
 import (
 	"context"
 	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
 )
 
 type Utils struct{}
 
+// Archive formats supported by Archive
+var archiveFormats = []string{"tar.gz", "tar", "zip"}
+
 // Get a tarball of a Directory
-func (m *Utils) Tar(dir *Directory) *File {
-	return dag.Container().
+//
+// In reproducible mode mtimes, owners and entry order are normalized so the
+// same Directory always produces a byte-identical tarball
+func (m *Utils) Tar(
+	dir *Directory,
+	// The gzip compression level, from 1 (fastest) to 9 (smallest)
+	// +optional
+	// +default=6
+	level int,
+	// Normalize the archive so its output is reproducible
+	// +optional
+	reproducible bool,
+	// The path the Directory is stored under within the archive
+	// +optional
+	// +default="/assets"
+	root string,
+) (*File, error) {
+	return m.Archive(dir, "tar.gz", level, reproducible, root)
+}
+
+// Get an archive of a Directory in the given format
+//
+// The returned File is named out.<format>
+func (m *Utils) Archive(
+	dir *Directory,
+	// The archive format, one of tar.gz, tar or zip
+	// +optional
+	// +default="tar.gz"
+	format string,
+	// The compression level, from 1 (fastest) to 9 (smallest), ignored for tar
+	// +optional
+	// +default=6
+	level int,
+	// Normalize the archive so its output is reproducible
+	// +optional
+	reproducible bool,
+	// The path the Directory is stored under within the archive
+	// +optional
+	// +default="/assets"
+	root string,
+) (*File, error) {
+	if !slices.Contains(archiveFormats, format) {
+		return nil, fmt.Errorf("unsupported archive format %q, expected one of %s", format, strings.Join(archiveFormats, ", "))
+	}
+	if level < 1 || level > 9 {
+		return nil, fmt.Errorf("compression level must be between 1 and 9, got %d", level)
+	}
+	root = "/" + strings.Trim(root, "/")
+	if root == "/" {
+		return nil, fmt.Errorf("archive root must not be /")
+	}
+
+	ctr := dag.Container().From("alpine:3.18")
+	out := "/out." + format
+	var script string
+	switch format {
+	case "zip":
+		ctr = ctr.WithExec([]string{"apk", "add", "--no-cache", "zip"})
+		flags := ""
+		if reproducible {
+			// zip can't store timestamps before 1980
+			script = `find "$1" -exec touch -h -d @315532800 {} + && `
+			flags = "-X"
+		}
+		script += fmt.Sprintf(`set -o pipefail; find "$1" | sort | zip -q %s -%d -@ %s`, flags, level, out)
+	default:
+		flags := ""
+		gzipFlags := ""
+		if reproducible {
+			// busybox tar can't normalize entries, use GNU tar and gzip instead
+			ctr = ctr.WithExec([]string{"apk", "add", "--no-cache", "tar", "gzip"})
+			flags = "--sort=name --mtime=@0 --owner=0 --group=0 --numeric-owner"
+			gzipFlags = "-n"
+		}
+		script = fmt.Sprintf(`set -o pipefail; tar %s -cf - "$1"`, flags)
+		if format == "tar.gz" {
+			script += fmt.Sprintf(" | gzip %s -%d", gzipFlags, level)
+		}
+		script += " > " + out
+	}
+
+	return ctr.
+		WithMountedDirectory(root, dir).
+		WithWorkdir("/").
+		WithExec([]string{"sh", "-c", script, "sh", strings.TrimPrefix(root, "/")}).
+		File(out), nil
+}
+
+// Finds symlinks resolving outside the directory they were extracted into
+const CHECK_LINKS = `cd "$1" && find . -type l | while IFS= read -r link; do
+  target=$(readlink -- "$link")
+  resolved=$(realpath -m -- "$(dirname -- "$link")/$target")
+  case "$target:$resolved" in
+    /*) echo "$link -> $target" ;;
+    *:"$1"|*:"$1"/*) ;;
+    *) echo "$link -> $target" ;;
+  esac
+done`
+
+// Extract an archive File into a Directory
+//
+// Archives containing absolute paths or entries escaping the archive root
+// (such as ../etc/passwd) are rejected before anything is extracted. Symlinks
+// with absolute targets or targets resolving outside the root are rejected
+// after extraction, which also catches entries written through them
+func (m *Utils) Extract(
+	ctx context.Context,
+	archive *File,
+	// The archive format, one of tar.gz, tar or zip, detected from the file name when empty
+	// +optional
+	format string,
+) (*Directory, error) {
+	if format == "" {
+		name, err := archive.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+		format = archiveFormat(name)
+		if format == "" {
+			return nil, fmt.Errorf("unable to detect the archive format of %s, pass a format", name)
+		}
+	}
+	if !slices.Contains(archiveFormats, format) {
+		return nil, fmt.Errorf("unsupported archive format %q, expected one of %s", format, strings.Join(archiveFormats, ", "))
+	}
+
+	list := []string{"tar", "-tf", "/archive"}
+	extract := []string{"tar", "--no-same-owner", "-xf", "/archive", "-C", "/out"}
+	if format == "zip" {
+		list = []string{"unzip", "-Z1", "/archive"}
+		extract = []string{"unzip", "-q", "/archive", "-d", "/out"}
+	}
+
+	ctr := dag.Container().
 		From("alpine:3.18").
-		WithMountedDirectory("/assets", dir).
-		WithExec([]string{"tar", "czf", "out.tar.gz", "/assets"}).
-		File("out.tar.gz")
+		WithExec([]string{"apk", "add", "--no-cache", "tar", "unzip", "coreutils"}).
+		WithMountedFile("/archive", archive)
+
+	entries, err := ctr.WithExec(list).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s archive: %w", format, err)
+	}
+	for _, entry := range strings.Split(entries, "\n") {
+		if entry == "" {
+			continue
+		}
+		if unsafeEntry(entry) {
+			return nil, fmt.Errorf("refusing to extract archive with unsafe entry %q", entry)
+		}
+	}
+
+	extracted := ctr.
+		WithExec([]string{"mkdir", "-p", "/out"}).
+		WithExec(extract)
+	links, err := extracted.
+		WithExec([]string{"sh", "-c", CHECK_LINKS, "sh", "/out"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract %s archive: %w", format, err)
+	}
+	if links != "" {
+		return nil, fmt.Errorf("refusing to extract archive with links escaping its root\n%s", links)
+	}
+	return extracted.Directory("/out"), nil
+}
+
+// The archive format of a file based on its extension
+func archiveFormat(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// Whether an archive entry would be written outside the extraction directory
+func unsafeEntry(entry string) bool {
+	if path.IsAbs(entry) {
+		return true
+	}
+	clean := path.Clean(entry)
+	return clean == ".." || strings.HasPrefix(clean, "../")
+}
+
+// The outcome of syncing a single Container
+type SyncResult struct {
+	// Position of the Container in the input list
+	Index int
+	// The synced Container, or nil if the sync failed
+	Container *Container
+	// Why the sync failed, empty on success
+	Error string
 }
 
 // Concurrently Sync multiple Containers
-func (m *Utils) Multisync(ctx context.Context, ctrs []*Container) ([]*Container, error) {
-	hck := dag.Directory()
+//
+// Returns the first error encountered, canceling the remaining syncs
+func (m *Utils) Multisync(
+	ctx context.Context,
+	ctrs []*Container,
+	// The most Containers to sync at once, 0 for no limit
+	// +optional
+	concurrency int,
+) ([]*Container, error) {
+	if concurrency < 0 {
+		return nil, fmt.Errorf("concurrency can't be negative, got %d", concurrency)
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	if concurrency > 0 {
+		eg.SetLimit(concurrency)
+	}
 
+	synced := make([]*Container, len(ctrs))
 	for i, ctr := range ctrs {
-		ctrFile := fmt.Sprintf("/syncfile%v", i)
-		// Magic link to directory
-		ctr = ctr.WithNewFile("/syncfile")
-		hck = hck.WithFile(ctrFile, ctr.File("/syncfile"))
+		i, ctr := i, ctr
+		eg.Go(func() error {
+			ctr, err := ctr.Sync(ctx)
+			if err != nil {
+				return fmt.Errorf("container %d: %w", i, err)
+			}
+			synced[i] = ctr
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return synced, nil
+}
 
-		ctrs[i] = ctr
+// Concurrently Sync multiple Containers, reporting the result of each
+//
+// Results are in the same order as the input, a failure doesn't cancel the others
+func (m *Utils) MultisyncResults(
+	ctx context.Context,
+	ctrs []*Container,
+	// The most Containers to sync at once, 0 for no limit
+	// +optional
+	concurrency int,
+) ([]*SyncResult, error) {
+	if concurrency < 0 {
+		return nil, fmt.Errorf("concurrency can't be negative, got %d", concurrency)
+	}
+	var eg errgroup.Group
+	if concurrency > 0 {
+		eg.SetLimit(concurrency)
 	}
 
-	_, err := hck.Entries(ctx)
+	results := make([]*SyncResult, len(ctrs))
+	for i, ctr := range ctrs {
+		i, ctr := i, ctr
+		eg.Go(func() error {
+			result := &SyncResult{Index: i}
+			synced, err := ctr.Sync(ctx)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Container = synced
+			}
+			results[i] = result
+			return nil
+		})
+	}
 
-	return ctrs, err
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
 }